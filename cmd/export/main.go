@@ -0,0 +1,179 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// export renders every theme, status code and language to static HTML files,
+// so the same pages can be served from S3 or nginx as a last-resort fallback
+// when Envoy itself is down. Pages are rendered exactly like the plugin's
+// pre-rendered pages, without request details.
+//
+// Usage:
+//
+//	go run ./cmd/export -out dist
+//	go run ./cmd/export -out dist -themes cats,connection -langs en,de
+//	go run ./cmd/export -out dist -config config.yaml
+//
+// Pages are written to {out}/{theme}/{lang}/{code}.html. With -config, pages
+// use the configured dark mode, theme variables and metadata, only the configured
+// themes are exported by default, and when the cdn block is enabled the
+// stylesheets the plugin links are written to {out}/stylesheets, ready to be
+// published at cdn.base_url.
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/config"
+	"github.com/ishioni/envoy-wasm-error-pages/l10n"
+	"github.com/ishioni/envoy-wasm-error-pages/themes"
+)
+
+func main() {
+	out := flag.String("out", "dist", "directory to write the pages to")
+	themeList := flag.String("themes", "", "comma-separated themes to export (default: all)")
+	langList := flag.String("langs", "", "comma-separated languages to export (default: all)")
+	version := flag.String("version", "dev", "version passed to the templates")
+	configPath := flag.String("config", "", "plugin configuration to render the pages with")
+	flag.Parse()
+
+	cfg := &config.Config{}
+	if *configPath != "" {
+		raw, err := os.ReadFile(*configPath)
+		if err != nil {
+			log.Fatalf("failed to read config: %v", err)
+		}
+		if cfg, err = config.Parse(raw); err != nil {
+			log.Fatalf("invalid config %s: %v", *configPath, err)
+		}
+	}
+
+	catalog, err := l10n.Load()
+	if err != nil {
+		log.Fatalf("failed to load translations: %v", err)
+	}
+
+	selected := splitList(*themeList)
+	if len(selected) == 0 && *configPath != "" {
+		selected = cfg.Themes()
+	}
+	if len(selected) == 0 {
+		if selected, err = themes.List(); err != nil {
+			log.Fatalf("failed to list themes: %v", err)
+		}
+	}
+	langs := splitList(*langList)
+	if len(langs) == 0 {
+		langs = catalog.Languages()
+	}
+	for _, lang := range langs {
+		if !catalog.Supported(lang) {
+			log.Fatalf("unsupported language %q (available: %s)", lang, strings.Join(catalog.Languages(), ", "))
+		}
+	}
+
+	localize := func(data *errorpages.TemplateData, lang string) {
+		translation := catalog.Get(lang)
+		data.Localize(lang, translation)
+		if text, ok := cfg.StatusText(data.Code, errorpages.IsKnownStatus(data.Code)); ok {
+			data.SetStatusText(text.Message, text.Description, translation)
+		}
+		data.DarkMode = cfg.DarkMode
+		// Exported pages carry no snippets, so they always reload with a meta
+		// refresh
+		data.RetryInterval = cmp.Or(cfg.Retry.Interval, -1)
+		data.Variables = cfg.ThemeVariables
+		data.TitlePrefix = cfg.Metadata.TitlePrefix
+		data.Favicon = cfg.Metadata.Favicon
+		data.OGTitle = cfg.Metadata.OGTitle
+		data.OGImage = cfg.Metadata.OGImage
+		data.SupportEmail = cfg.Support.Email
+		data.SupportPhone = cfg.Support.Phone
+		data.SupportTel = cfg.Support.TelURL()
+		data.CustomScriptURL = cfg.CustomScript.URL
+		data.CustomScriptInline = cfg.CustomScript.Inline
+	}
+
+	codes := errorpages.StatusCodes(cfg.CustomStatusCodes())
+	count, stylesheets := 0, 0
+	for _, theme := range selected {
+		handler, err := themes.New(theme, *version)
+		if err != nil {
+			log.Fatalf("failed to load theme %q: %v", theme, err)
+		}
+		if cfg.CDN.Enabled {
+			files, err := handler.UseStylesheets(cfg.CDN.BaseURL, func(data *errorpages.TemplateData) {
+				localize(data, cfg.Localization.DefaultLanguage)
+			})
+			if err != nil {
+				log.Fatalf("failed to render stylesheets of theme %q: %v", theme, err)
+			}
+			if err := writeFiles(filepath.Join(*out, "stylesheets"), files); err != nil {
+				log.Fatal(err)
+			}
+			stylesheets += len(files)
+		}
+		if err := handler.Prerender(codes, langs, localize); err != nil {
+			log.Fatalf("failed to render theme %q: %v", theme, err)
+		}
+		for _, lang := range langs {
+			dir := filepath.Join(*out, theme, lang)
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				log.Fatalf("failed to create %s: %v", dir, err)
+			}
+			for _, code := range codes {
+				page, _ := handler.CachedPage(code, lang)
+				path := filepath.Join(dir, strconv.Itoa(code)+".html")
+				if err := os.WriteFile(path, page, 0o644); err != nil {
+					log.Fatalf("failed to write %s: %v", path, err)
+				}
+				count++
+			}
+		}
+	}
+	fmt.Printf("Exported %d pages (%d themes, %d languages) to %s\n", count, len(selected), len(langs), *out)
+	if cfg.CDN.Enabled {
+		fmt.Printf("Wrote %d stylesheets to %s, publish them at %s\n", stylesheets, filepath.Join(*out, "stylesheets"), cfg.CDN.BaseURL)
+	}
+}
+
+// writeFiles writes files into dir, creating it if needed
+func writeFiles(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,185 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gallery renders a static, browsable gallery of every theme at a few
+// representative status codes, so teams can pick a theme without deploying
+// anything. The index shows a scaled-down live preview of each page; open
+// it straight from disk or publish the directory anywhere.
+//
+// Usage:
+//
+//	go run ./cmd/gallery -out gallery
+//	go run ./cmd/gallery -out gallery -codes 404,503 -lang de
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/l10n"
+	"github.com/ishioni/envoy-wasm-error-pages/themes"
+)
+
+// index is the gallery page; each preview is a full-size iframe scaled down
+// with CSS, linking to the page itself
+var index = template.Must(template.New("index").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Error page themes</title>
+<style>
+body { margin: 0; padding: 2rem; font-family: system-ui, sans-serif; background: #f4f4f6; color: #222; }
+h1 { margin: 0 0 .25rem; }
+p.note { margin: 0 0 2rem; color: #666; }
+section { margin-bottom: 2.5rem; }
+h2 { margin: 0 0 .75rem; font-size: 1.25rem; }
+h2 code { font-size: .9rem; color: #666; font-weight: normal; margin-left: .5rem; }
+.pages { display: flex; flex-wrap: wrap; gap: 1rem; }
+figure { margin: 0; background: #fff; border-radius: 8px; box-shadow: 0 1px 4px rgba(0,0,0,.12); overflow: hidden; }
+.frame { width: 320px; height: 200px; overflow: hidden; position: relative; }
+.frame iframe { width: 1280px; height: 800px; border: 0; transform: scale(.25); transform-origin: 0 0; pointer-events: none; }
+.frame a { position: absolute; inset: 0; }
+figcaption { padding: .5rem .75rem; font-size: .9rem; }
+</style>
+</head>
+<body>
+<h1>Error page themes</h1>
+<p class="note">{{ len .Themes }} themes, rendered with sample request details ({{ .Lang }}). Click a preview to open the full page.</p>
+{{ range .Themes }}<section id="{{ .Name }}">
+<h2>{{ .Name }}<code>theme: {{ .Name }}</code></h2>
+<div class="pages">
+{{ range .Pages }}<figure>
+<div class="frame"><iframe src="{{ .Path }}" loading="lazy" tabindex="-1" title="{{ .Title }}"></iframe><a href="{{ .Path }}" aria-label="{{ .Title }}"></a></div>
+<figcaption>{{ .Code }} {{ .Message }}</figcaption>
+</figure>
+{{ end }}</div>
+</section>
+{{ end }}</body>
+</html>
+`))
+
+type galleryTheme struct {
+	Name  string
+	Pages []galleryPage
+}
+
+type galleryPage struct {
+	Path    string
+	Title   string
+	Code    int
+	Message string
+}
+
+func main() {
+	out := flag.String("out", "gallery", "directory to write the gallery to")
+	codeList := flag.String("codes", "404,429,500,503", "comma-separated status codes to render for every theme")
+	lang := flag.String("lang", l10n.DefaultLanguage, "language of the rendered pages")
+	flag.Parse()
+
+	var codes []int
+	for _, s := range strings.Split(*codeList, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || code < 400 || code > 599 {
+			log.Fatalf("Invalid status code %q", s)
+		}
+		codes = append(codes, code)
+	}
+
+	catalog, err := l10n.Load()
+	if err != nil {
+		log.Fatalf("Failed to load translations: %v", err)
+	}
+	if !catalog.Supported(*lang) {
+		log.Fatalf("Unsupported language %q (available: %s)", *lang, strings.Join(catalog.Languages(), ", "))
+	}
+	names, err := themes.List()
+	if err != nil {
+		log.Fatalf("Failed to list themes: %v", err)
+	}
+
+	var gallery []galleryTheme
+	for _, name := range names {
+		handler, err := themes.New(name, "gallery")
+		if err != nil {
+			log.Fatalf("Failed to load theme %q: %v", name, err)
+		}
+		if err := os.MkdirAll(filepath.Join(*out, name), 0o755); err != nil {
+			log.Fatalf("Failed to create %s: %v", filepath.Join(*out, name), err)
+		}
+
+		theme := galleryTheme{Name: name}
+		for _, code := range codes {
+			data := sampleData(code)
+			data.Localize(*lang, catalog.Get(*lang))
+			page, err := handler.RenderErrorPage(data)
+			if err != nil {
+				log.Fatalf("Failed to render theme %q (%d): %v", name, code, err)
+			}
+			path := name + "/" + strconv.Itoa(code) + ".html"
+			if err := os.WriteFile(filepath.Join(*out, filepath.FromSlash(path)), page, 0o644); err != nil {
+				log.Fatalf("Failed to write %s: %v", path, err)
+			}
+			theme.Pages = append(theme.Pages, galleryPage{
+				Path:    path,
+				Title:   fmt.Sprintf("%s %d", name, code),
+				Code:    code,
+				Message: data.Message,
+			})
+		}
+		gallery = append(gallery, theme)
+	}
+
+	f, err := os.Create(filepath.Join(*out, "index.html"))
+	if err != nil {
+		log.Fatalf("Failed to create index: %v", err)
+	}
+	defer f.Close()
+	if err := index.Execute(f, struct {
+		Themes []galleryTheme
+		Lang   string
+	}{gallery, *lang}); err != nil {
+		log.Fatalf("Failed to write index: %v", err)
+	}
+	fmt.Printf("Wrote gallery of %d themes to %s\n", len(gallery), filepath.Join(*out, "index.html"))
+}
+
+// sampleData returns template data with plausible request details
+func sampleData(code int) *errorpages.TemplateData {
+	return &errorpages.TemplateData{
+		Code:         code,
+		ShowDetails:  true,
+		Host:         "www.example.com",
+		OriginalURI:  "/checkout/cart?step=2",
+		ForwardedFor: "203.0.113.10, 10.0.0.1",
+		RequestID:    "3f2a9c1e-7b4d-4e8a-9c61-2d5f0a8b7e13",
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		Attempts:     3,
+		RequestSize:  2048,
+		ResponseSize: 1536,
+		TLSVersion:   "TLSv1.3",
+		PeerSAN:      "spiffe://cluster.local/ns/default/sa/checkout",
+		ServedBy:     "envoy-gateway-7f9c6d5b8-x2xkq (eu-west-1, eu-west-1a)",
+
+		CorrelationIDs: [][2]string{{"x-correlation-id", "c0ffee00-1234-4abc-8def-0123456789ab"}},
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gen-manifests turns the plugin configuration into a Kubernetes manifest that
+// deploys the plugin, with the configuration inlined and the image pinned by
+// its sha256 digest, so rollouts don't depend on copy-pasting YAML. Supported
+// kinds are Istio's WasmPlugin and EnvoyFilter, and Envoy Gateway's
+// EnvoyExtensionPolicy.
+//
+// Usage:
+//
+//	go run ./cmd/gen-manifests -config config.yaml -image ghcr.io/acme/error-pages:v1.2.0 -wasm main.wasm
+//	go run ./cmd/gen-manifests -kind envoyfilter -image ghcr.io/acme/error-pages:v1.2.0 -sha256 <digest>
+//	go run ./cmd/gen-manifests -kind envoyextensionpolicy -gateway eg -image ghcr.io/acme/error-pages:v1.2.0 -sha256 <digest>
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/config"
+	"github.com/ishioni/envoy-wasm-error-pages/themes"
+)
+
+// Supported manifest kinds
+const (
+	kindWasmPlugin           = "wasmplugin"
+	kindEnvoyFilter          = "envoyfilter"
+	kindEnvoyExtensionPolicy = "envoyextensionpolicy"
+)
+
+const wasmFilterType = "type.googleapis.com/envoy.extensions.filters.http.wasm.v3.Wasm"
+
+// pluginName must match the name of the plugin in the Envoy configuration
+const pluginName = "error_pages"
+
+var sha256Digest = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// object is a loosely typed manifest fragment; yaml.v3 sorts its keys, which
+// conveniently puts apiVersion, kind, metadata and spec in the usual order
+type object = map[string]any
+
+type options struct {
+	kind      string
+	name      string
+	namespace string
+	image     string
+	sha256    string
+	selector  map[string]string
+	gateway   string
+}
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "plugin configuration file to inline")
+	kind := flag.String("kind", kindWasmPlugin, "manifest kind: wasmplugin, envoyfilter or envoyextensionpolicy")
+	name := flag.String("name", "error-pages", "name of the generated resource")
+	namespace := flag.String("namespace", "", "namespace of the generated resource")
+	image := flag.String("image", "", "OCI image of the plugin, e.g. ghcr.io/acme/error-pages:v1.2.0")
+	digest := flag.String("sha256", "", "sha256 of the plugin wasm module")
+	wasmPath := flag.String("wasm", "", "plugin wasm module to compute -sha256 from")
+	selector := flag.String("selector", "istio=ingressgateway", "Istio workload labels, comma-separated key=value pairs")
+	gateway := flag.String("gateway", "", "Envoy Gateway Gateway the policy attaches to")
+	out := flag.String("o", "", "output file (default: stdout)")
+	flag.Parse()
+
+	raw, err := os.ReadFile(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *configPath, err)
+	}
+	cfg, err := config.Parse(raw)
+	if err != nil {
+		log.Fatalf("Invalid plugin configuration %s: %v", *configPath, err)
+	}
+	for _, theme := range cfg.Themes() {
+		if _, err := themes.Info(theme); err != nil {
+			log.Fatalf("Invalid plugin configuration %s: unknown theme %q", *configPath, theme)
+		}
+	}
+	var pluginConfig object
+	if err := yaml.Unmarshal(raw, &pluginConfig); err != nil {
+		log.Fatalf("Failed to parse %s: %v", *configPath, err)
+	}
+	if pluginConfig == nil {
+		pluginConfig = object{}
+	}
+
+	opts := options{
+		kind:      strings.ToLower(*kind),
+		name:      *name,
+		namespace: *namespace,
+		image:     strings.TrimPrefix(*image, "oci://"),
+		sha256:    strings.ToLower(*digest),
+		gateway:   *gateway,
+	}
+	if opts.image == "" {
+		log.Fatalf("-image is required")
+	}
+	if *wasmPath != "" {
+		module, err := os.ReadFile(*wasmPath)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *wasmPath, err)
+		}
+		sum := sha256.Sum256(module)
+		opts.sha256 = hex.EncodeToString(sum[:])
+	}
+	if opts.sha256 == "" {
+		log.Fatalf("-sha256 or -wasm is required to pin the plugin")
+	}
+	if !sha256Digest.MatchString(opts.sha256) {
+		log.Fatalf("-sha256 must be 64 hex characters, got %q", opts.sha256)
+	}
+	if opts.selector, err = parseSelector(*selector); err != nil {
+		log.Fatalf("Invalid -selector: %v", err)
+	}
+
+	var manifest object
+	switch opts.kind {
+	case kindWasmPlugin:
+		manifest = wasmPlugin(opts, pluginConfig)
+	case kindEnvoyFilter:
+		configJSON, err := json.Marshal(pluginConfig)
+		if err != nil {
+			log.Fatalf("Failed to encode %s: %v", *configPath, err)
+		}
+		manifest = envoyFilter(opts, string(configJSON))
+	case kindEnvoyExtensionPolicy:
+		if opts.gateway == "" {
+			log.Fatalf("-gateway is required for %s", kindEnvoyExtensionPolicy)
+		}
+		manifest = envoyExtensionPolicy(opts, pluginConfig)
+	default:
+		log.Fatalf("Unknown -kind %q (must be %s, %s or %s)", *kind, kindWasmPlugin, kindEnvoyFilter, kindEnvoyExtensionPolicy)
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "# Code generated by cmd/gen-manifests from %s. DO NOT EDIT.\n", filepath.Base(*configPath))
+	enc := yaml.NewEncoder(&body)
+	enc.SetIndent(2)
+	if err := enc.Encode(manifest); err != nil {
+		log.Fatalf("Failed to encode manifest: %v", err)
+	}
+	content := body.Bytes()
+	if *out == "" {
+		_, _ = os.Stdout.Write(content)
+		return
+	}
+	if err := os.WriteFile(*out, content, 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *out, err)
+	}
+	fmt.Printf("Wrote %s manifest to %s\n", opts.kind, *out)
+}
+
+// parseSelector parses comma-separated key=value pairs
+func parseSelector(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		labels[key] = value
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("at least one label is required")
+	}
+	return labels, nil
+}
+
+func metadata(opts options) object {
+	meta := object{"name": opts.name}
+	if opts.namespace != "" {
+		meta["namespace"] = opts.namespace
+	}
+	return meta
+}
+
+// wasmPlugin builds an Istio WasmPlugin, which passes pluginConfig to the
+// plugin as JSON
+func wasmPlugin(opts options, pluginConfig object) object {
+	return object{
+		"apiVersion": "extensions.istio.io/v1alpha1",
+		"kind":       "WasmPlugin",
+		"metadata":   metadata(opts),
+		"spec": object{
+			"selector":     object{"matchLabels": opts.selector},
+			"url":          "oci://" + opts.image,
+			"sha256":       opts.sha256,
+			"pluginName":   pluginName,
+			"pluginConfig": pluginConfig,
+		},
+	}
+}
+
+// envoyFilter builds an Istio EnvoyFilter that adds the plugin as an
+// extension config, fetched and verified by the Istio agent, and inserts it
+// before the router of gateway listeners
+func envoyFilter(opts options, configJSON string) object {
+	extension := object{
+		"name": opts.name,
+		"typed_config": object{
+			"@type": wasmFilterType,
+			"config": object{
+				"name": pluginName,
+				"configuration": object{
+					"@type": "type.googleapis.com/google.protobuf.StringValue",
+					"value": configJSON,
+				},
+				"vm_config": object{
+					"runtime": "envoy.wasm.runtime.v8",
+					"code": object{
+						"remote": object{
+							"http_uri": object{
+								"uri":     "oci://" + opts.image,
+								"timeout": "10s",
+							},
+							"sha256": opts.sha256,
+						},
+					},
+				},
+			},
+		},
+	}
+	filter := object{
+		"name": opts.name,
+		"config_discovery": object{
+			"config_source": object{"ads": object{}},
+			"type_urls":     []string{wasmFilterType},
+		},
+	}
+	return object{
+		"apiVersion": "networking.istio.io/v1alpha3",
+		"kind":       "EnvoyFilter",
+		"metadata":   metadata(opts),
+		"spec": object{
+			"workloadSelector": object{"labels": opts.selector},
+			"configPatches": []object{
+				{
+					"applyTo": "EXTENSION_CONFIG",
+					"patch":   object{"operation": "ADD", "value": extension},
+				},
+				{
+					"applyTo": "HTTP_FILTER",
+					"match": object{
+						"context": "GATEWAY",
+						"listener": object{
+							"filterChain": object{
+								"filter": object{
+									"name":      "envoy.filters.network.http_connection_manager",
+									"subFilter": object{"name": "envoy.filters.http.router"},
+								},
+							},
+						},
+					},
+					"patch": object{"operation": "INSERT_BEFORE", "value": filter},
+				},
+			},
+		},
+	}
+}
+
+// envoyExtensionPolicy builds an Envoy Gateway EnvoyExtensionPolicy attached
+// to a Gateway
+func envoyExtensionPolicy(opts options, pluginConfig object) object {
+	return object{
+		"apiVersion": "gateway.envoyproxy.io/v1alpha1",
+		"kind":       "EnvoyExtensionPolicy",
+		"metadata":   metadata(opts),
+		"spec": object{
+			"targetRefs": []object{{
+				"group": "gateway.networking.k8s.io",
+				"kind":  "Gateway",
+				"name":  opts.gateway,
+			}},
+			"wasm": []object{{
+				"name": pluginName,
+				"code": object{
+					"type": "Image",
+					"image": object{
+						"url":    opts.image,
+						"sha256": opts.sha256,
+					},
+				},
+				"config": pluginConfig,
+			}},
+		},
+	}
+}
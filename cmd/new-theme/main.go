@@ -0,0 +1,112 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// new-theme creates a theme skeleton in the templates directory: a complete
+// page with every placeholder, the retry and details conditionals, the
+// details table, dark mode and the client-side localization script wired
+// up, plus its manifest. Every theme in the templates directory is embedded
+// into the plugin, so the new theme can be selected with `theme: <name>`
+// right away.
+//
+// Usage:
+//
+//	go run ./cmd/new-theme -name my-theme
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/templates"
+)
+
+//go:embed skeleton.html
+var skeleton string
+
+// themeName keeps names usable in config files, URLs and file names
+var themeName = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func main() {
+	name := flag.String("name", "", "name of the new theme, e.g. my-theme")
+	dir := flag.String("dir", "templates", "templates directory")
+	flag.Parse()
+
+	if !themeName.MatchString(*name) {
+		log.Fatalf("-name must be lowercase letters, digits and dashes, got %q", *name)
+	}
+	page := strings.ReplaceAll(skeleton, "__THEME_NAME__", *name)
+	if _, err := errorpages.NewWithTemplate([]byte(page), "dev"); err != nil {
+		log.Fatalf("Skeleton does not parse: %v", err)
+	}
+
+	path := filepath.Join(*dir, *name+".html")
+	manifestPath := filepath.Join(*dir, *name+".json")
+	manifest, err := json.MarshalIndent(templates.Theme{
+		DisplayName: displayName(*name),
+		Description: "TODO: describe the theme",
+		DarkMode:    true,
+		L10n:        true,
+	}, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode manifest: %v", err)
+	}
+	if err := create(path, []byte(page)); err != nil {
+		log.Fatal(err)
+	}
+	if err := create(manifestPath, append(manifest, '\n')); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Created %s and %s\n\n", path, manifestPath)
+	fmt.Printf("Next steps:\n")
+	fmt.Printf("  go run ./cmd/preview           # open http://localhost:8080/%s/503\n", *name)
+	fmt.Printf("  go run ./cmd/validate %s\n", path)
+	fmt.Printf("  describe the theme in %s\n", manifestPath)
+	fmt.Printf("  set `theme: %s` in config.yaml and rebuild the plugin\n", *name)
+}
+
+// create writes a new file, refusing to overwrite an existing theme
+func create(path string, content []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// displayName turns my-theme into My Theme
+func displayName(name string) string {
+	words := strings.Split(name, "-")
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,166 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// package wraps the built plugin in an OCI artifact following the wasm image
+// spec (config application/vnd.module.wasm.config.v1+json, a single
+// application/vnd.module.wasm.content.layer.v1+wasm layer), which Istio and
+// Envoy Gateway pull natively. The artifact is written as an OCI image layout
+// directory tagged with the version and, with -push, uploaded to a registry.
+//
+// Usage:
+//
+//	go run ./cmd/package -wasm main.wasm -tag v1.2.0
+//	REGISTRY_USERNAME=me REGISTRY_PASSWORD=$TOKEN go run ./cmd/package -wasm main.wasm -tag v1.2.0 -push ghcr.io/acme/error-pages
+//
+// Credentials are read from REGISTRY_USERNAME and REGISTRY_PASSWORD; pushing
+// anonymously works for registries that allow it.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Media types of the wasm image spec
+const (
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeConfig   = "application/vnd.module.wasm.config.v1+json"
+	mediaTypeLayer    = "application/vnd.module.wasm.content.layer.v1+wasm"
+)
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        descriptor        `json:"config"`
+	Layers        []descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+// blob is content addressed by its sha256 digest
+type blob struct {
+	descriptor
+	data []byte
+}
+
+func newBlob(mediaType string, data []byte) blob {
+	sum := sha256.Sum256(data)
+	return blob{
+		descriptor: descriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: len(data)},
+		data:       data,
+	}
+}
+
+func main() {
+	wasmPath := flag.String("wasm", "main.wasm", "built plugin module")
+	tag := flag.String("tag", "dev", "version to tag the artifact with")
+	out := flag.String("out", "oci", "directory to write the OCI image layout to")
+	push := flag.String("push", "", "repository to push to, e.g. ghcr.io/acme/error-pages")
+	plainHTTP := flag.Bool("plain-http", false, "push over HTTP, e.g. to a local registry")
+	flag.Parse()
+
+	module, err := os.ReadFile(*wasmPath)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *wasmPath, err)
+	}
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	layer := newBlob(mediaTypeLayer, module)
+	layer.Annotations = map[string]string{"org.opencontainers.image.title": "plugin.wasm"}
+	config := newBlob(mediaTypeConfig, mustJSON(map[string]any{
+		"created":     created,
+		"description": "Envoy WASM error pages plugin",
+	}))
+	manifestBlob := newBlob(mediaTypeManifest, mustJSON(manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifest,
+		Config:        config.descriptor,
+		Layers:        []descriptor{layer.descriptor},
+		Annotations: map[string]string{
+			"org.opencontainers.image.version": *tag,
+			"org.opencontainers.image.created": created,
+		},
+	}))
+
+	if err := writeLayout(*out, *tag, manifestBlob, config, layer); err != nil {
+		log.Fatalf("Failed to write OCI layout: %v", err)
+	}
+	fmt.Printf("Wrote %s:%s to %s\n", filepath.Base(*wasmPath), *tag, *out)
+	fmt.Printf("  manifest digest: %s\n", manifestBlob.Digest)
+	fmt.Printf("  module sha256:   %s\n", layer.Digest[len("sha256:"):])
+
+	if *push == "" {
+		return
+	}
+	r, err := newRegistry(*push, *plainHTTP, os.Getenv("REGISTRY_USERNAME"), os.Getenv("REGISTRY_PASSWORD"))
+	if err != nil {
+		log.Fatalf("Invalid -push: %v", err)
+	}
+	for _, b := range []blob{config, layer} {
+		if err := r.pushBlob(b); err != nil {
+			log.Fatalf("Failed to push %s: %v", b.Digest, err)
+		}
+	}
+	if err := r.pushManifest(*tag, manifestBlob); err != nil {
+		log.Fatalf("Failed to push manifest: %v", err)
+	}
+	fmt.Printf("Pushed %s:%s (%s)\n", *push, *tag, manifestBlob.Digest)
+}
+
+// writeLayout writes the blobs as an OCI image layout with the manifest
+// tagged in index.json
+func writeLayout(dir, tag string, manifestBlob blob, blobs ...blob) error {
+	blobDir := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		return err
+	}
+	for _, b := range append(blobs, manifestBlob) {
+		if err := os.WriteFile(filepath.Join(blobDir, b.Digest[len("sha256:"):]), b.data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	tagged := manifestBlob.descriptor
+	tagged.Annotations = map[string]string{"org.opencontainers.image.ref.name": tag}
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), mustJSON(index{SchemaVersion: 2, Manifests: []descriptor{tagged}}), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644)
+}
+
+func mustJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Fatalf("Failed to encode JSON: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,224 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// registry pushes to a repository with the OCI distribution API
+type registry struct {
+	base     string // scheme and host, e.g. https://ghcr.io
+	repo     string // repository path, e.g. acme/error-pages
+	username string
+	password string
+	token    string // bearer token, fetched on the first 401
+	client   *http.Client
+}
+
+func newRegistry(ref string, plainHTTP bool, username, password string) (*registry, error) {
+	host, repo, ok := strings.Cut(strings.TrimPrefix(ref, "oci://"), "/")
+	if !ok || repo == "" || !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return nil, fmt.Errorf("%q must be registry-host/repository", ref)
+	}
+	if strings.Contains(repo, ":") || strings.Contains(repo, "@") {
+		return nil, fmt.Errorf("%q must not include a tag or digest, use -tag", ref)
+	}
+	scheme := "https"
+	if plainHTTP {
+		scheme = "http"
+	}
+	return &registry{
+		base:     scheme + "://" + host,
+		repo:     repo,
+		username: username,
+		password: password,
+		client:   http.DefaultClient,
+	}, nil
+}
+
+// pushBlob uploads a blob in a single request, unless the registry has it
+func (r *registry) pushBlob(b blob) error {
+	resp, err := r.do(http.MethodHead, r.base+"/v2/"+r.repo+"/blobs/"+b.Digest, "", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = r.do(http.MethodPost, r.base+"/v2/"+r.repo+"/blobs/uploads/", "", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return statusError("start upload", resp)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("start upload: %w", err)
+	}
+	q := location.Query()
+	q.Set("digest", b.Digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = r.do(http.MethodPut, location.String(), "application/octet-stream", b.data)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return statusError("upload", resp)
+	}
+	return nil
+}
+
+// pushManifest uploads the manifest under tag
+func (r *registry) pushManifest(tag string, m blob) error {
+	resp, err := r.do(http.MethodPut, r.base+"/v2/"+r.repo+"/manifests/"+tag, m.MediaType, m.data)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return statusError("put manifest", resp)
+	}
+	return nil
+}
+
+// do sends a request, authenticating and retrying once if the registry
+// answers 401. The response body is read and closed.
+func (r *registry) do(method, rawURL, contentType string, body []byte) (*http.Response, error) {
+	resp, err := r.send(method, rawURL, contentType, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if err := r.authenticate(resp.Header.Get("www-authenticate")); err != nil {
+		return nil, err
+	}
+	return r.send(method, rawURL, contentType, body)
+}
+
+func (r *registry) send(method, rawURL, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("content-type", contentType)
+	}
+	switch {
+	case r.token != "":
+		req.Header.Set("authorization", "Bearer "+r.token)
+	case r.username != "":
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Keep a short excerpt for error messages
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body = io.NopCloser(bytes.NewReader(excerpt))
+	return resp, nil
+}
+
+// authenticate handles a WWW-Authenticate challenge. Basic challenges are
+// answered with the credentials on the retry; bearer challenges exchange
+// them for a token with push access to the repository.
+func (r *registry) authenticate(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.username == "" {
+			return fmt.Errorf("registry requires credentials, set REGISTRY_USERNAME and REGISTRY_PASSWORD")
+		}
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+
+	attrs := parseChallenge(params)
+	realm, err := url.Parse(attrs["realm"])
+	if err != nil || attrs["realm"] == "" {
+		return fmt.Errorf("invalid bearer challenge %q", challenge)
+	}
+	q := realm.Query()
+	if attrs["service"] != "" {
+		q.Set("service", attrs["service"])
+	}
+	q.Set("scope", "repository:"+r.repo+":pull,push")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError("fetch token", resp)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("fetch token: %w", err)
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	if r.token == "" {
+		return fmt.Errorf("fetch token: empty token")
+	}
+	return nil
+}
+
+// parseChallenge parses the key="value" pairs of a WWW-Authenticate header
+func parseChallenge(params string) map[string]string {
+	attrs := make(map[string]string)
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(params, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+			params = strings.TrimPrefix(params, ",")
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		attrs[key] = value
+	}
+	return attrs
+}
+
+func statusError(action string, resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("%s: %s: %s", action, resp.Status, strings.TrimSpace(string(body)))
+}
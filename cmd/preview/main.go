@@ -0,0 +1,272 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// preview serves every theme with fake request data, so theme authors can
+// iterate without building the wasm plugin or running Envoy. Themes are read
+// from -dir on every request and open pages reload when a theme changes.
+//
+// Usage:
+//
+//	go run ./cmd/preview -port 8080
+//
+// Then open http://localhost:8080/ or http://localhost:8080/{theme}/{code}.
+// Query parameters: lang (e.g. ?lang=de), details=0 to hide the request
+// details, dark (auto, always or never) to force the color scheme and
+// var.<name> to set a theme variable, e.g. ?var.accent_color=%23c8102e.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/l10n"
+	"github.com/ishioni/envoy-wasm-error-pages/templates"
+	"github.com/ishioni/envoy-wasm-error-pages/themes"
+)
+
+// versionPath returns a fingerprint of the themes, polled by reloadSnippet
+const versionPath = "/__preview/version"
+
+// reloadSnippet reloads the page once the theme fingerprint changes
+const reloadSnippet = `<script>(function(){var v=null;setInterval(function(){` +
+	`fetch("` + versionPath + `").then(function(r){return r.text()}).then(function(t){` +
+	`if(v!==null&&t!==v){location.reload()}v=t}).catch(function(){})},1000)})();</script>`
+
+// sampleCodes are linked from the index page for every theme
+var sampleCodes = []int{404, 429, 500, 503}
+
+type server struct {
+	dir     string
+	catalog *l10n.Catalog
+}
+
+func main() {
+	port := flag.Int("port", 8080, "port to listen on")
+	dir := flag.String("dir", "templates", "directory to read themes from; embedded themes are used when it does not exist")
+	flag.Parse()
+
+	catalog, err := l10n.Load()
+	if err != nil {
+		log.Fatalf("failed to load translations: %v", err)
+	}
+	s := &server{dir: *dir, catalog: catalog}
+	if _, err := os.Stat(s.dir); err != nil {
+		log.Printf("theme directory %q not found, serving embedded themes without live reload", s.dir)
+		s.dir = ""
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.index)
+	mux.HandleFunc("GET "+versionPath, s.version)
+	mux.HandleFunc("GET /{theme}/{code}", s.page)
+
+	addr := "localhost:" + strconv.Itoa(*port)
+	log.Printf("previewing themes at http://%s/", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// themes lists the available theme names
+func (s *server) themes() ([]string, error) {
+	if s.dir == "" {
+		return themes.List()
+	}
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = strings.TrimSuffix(filepath.Base(m), ".html")
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// info returns the metadata of a theme, from disk when previewing a directory
+func (s *server) info(theme string) (themes.Theme, error) {
+	if s.dir == "" {
+		return themes.Info(theme)
+	}
+	raw, err := s.template(theme)
+	if err != nil {
+		return themes.Theme{}, err
+	}
+	manifest, err := os.ReadFile(filepath.Join(s.dir, theme+".json"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return themes.Theme{}, err
+	}
+	return templates.NewTheme(theme, raw, manifest)
+}
+
+// template reads a theme, from disk when previewing a directory
+func (s *server) template(theme string) ([]byte, error) {
+	if s.dir == "" {
+		return themes.Get(theme)
+	}
+	if strings.ContainsAny(theme, `/\`) || strings.HasPrefix(theme, ".") {
+		return nil, fmt.Errorf("invalid theme %q", theme)
+	}
+	return os.ReadFile(filepath.Join(s.dir, theme+".html"))
+}
+
+func (s *server) index(w http.ResponseWriter, r *http.Request) {
+	names, err := s.themes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var b strings.Builder
+	b.WriteString("<!doctype html><meta charset=\"utf-8\"><title>Error page themes</title><h1>Error page themes</h1><ul>")
+	for _, name := range names {
+		theme, err := s.info(name)
+		if err != nil {
+			fmt.Fprintf(&b, "<li><code>%s</code>: %s</li>", html.EscapeString(name), html.EscapeString(err.Error()))
+			continue
+		}
+		fmt.Fprintf(&b, "<li><strong>%s</strong> <code>%s</code>", html.EscapeString(theme.DisplayName), html.EscapeString(name))
+		if theme.DarkMode {
+			b.WriteString(" [dark mode]")
+		}
+		if theme.L10n {
+			b.WriteString(" [l10n]")
+		}
+		fmt.Fprintf(&b, "<br>%s<br>", html.EscapeString(theme.Description))
+		for _, code := range sampleCodes {
+			fmt.Fprintf(&b, ` <a href="/%s/%d">%d</a>`, html.EscapeString(name), code, code)
+		}
+		fmt.Fprintf(&b, "<br><small>%s</small>", html.EscapeString(strings.Join(theme.Placeholders, ", ")))
+		if len(theme.Variables) > 0 {
+			names := make([]string, 0, len(theme.Variables))
+			for name := range theme.Variables {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			b.WriteString("<br><small>Variables:")
+			for _, name := range names {
+				fmt.Fprintf(&b, " <code title=\"%s\">%s</code>", html.EscapeString(theme.Variables[name].Description), html.EscapeString(name))
+			}
+			b.WriteString("</small>")
+		}
+		b.WriteString("</li>")
+	}
+	fmt.Fprintf(&b, "</ul><p>Languages: %s (use ?lang=)</p>", strings.Join(s.catalog.Languages(), ", "))
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// version fingerprints the modification times and sizes of the themes
+func (s *server) version(w http.ResponseWriter, r *http.Request) {
+	h := sha256.New()
+	if s.dir != "" {
+		matches, _ := filepath.Glob(filepath.Join(s.dir, "*.html"))
+		manifests, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+		for _, m := range append(matches, manifests...) {
+			if info, err := os.Stat(m); err == nil {
+				fmt.Fprintf(h, "%s:%d:%d\n", m, info.ModTime().UnixNano(), info.Size())
+			}
+		}
+	}
+	w.Header().Set("cache-control", "no-store")
+	_, _ = w.Write([]byte(hex.EncodeToString(h.Sum(nil))))
+}
+
+func (s *server) page(w http.ResponseWriter, r *http.Request) {
+	code, err := strconv.Atoi(r.PathValue("code"))
+	if err != nil || code < 100 || code > 599 {
+		http.Error(w, "invalid status code", http.StatusBadRequest)
+		return
+	}
+	raw, err := s.template(r.PathValue("theme"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	theme, err := s.info(r.PathValue("theme"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handler, err := errorpages.NewWithTemplate(raw, "preview")
+	if err == nil {
+		handler.SetVariableDefaults(theme.VariableDefaults())
+	}
+	if err == nil && s.dir != "" {
+		err = handler.AddSnippet(reloadSnippet)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	lang := r.URL.Query().Get("lang")
+	if lang == "" || !s.catalog.Supported(lang) {
+		lang = l10n.DefaultLanguage
+	}
+	data := fakeData(code, r.URL.Query().Get("details") != "0")
+	data.Localize(lang, s.catalog.Get(lang))
+	data.DarkMode = r.URL.Query().Get("dark")
+	data.Variables = make(map[string]string)
+	for key, values := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(key, "var."); ok {
+			data.Variables[name] = values[0]
+		}
+	}
+
+	page, err := handler.RenderErrorPage(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(code)
+	_, _ = w.Write(page)
+}
+
+// fakeData returns template data with plausible request details
+func fakeData(code int, details bool) *errorpages.TemplateData {
+	return &errorpages.TemplateData{
+		Code:         code,
+		ShowDetails:  details,
+		Host:         "www.example.com",
+		OriginalURI:  "/checkout/cart?step=2",
+		ForwardedFor: "203.0.113.10, 10.0.0.1",
+		RequestID:    "3f2a9c1e-7b4d-4e8a-9c61-2d5f0a8b7e13",
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		Attempts:     3,
+		RequestSize:  2048,
+		ResponseSize: 1536,
+		TLSVersion:   "TLSv1.3",
+		PeerSAN:      "spiffe://cluster.local/ns/default/sa/checkout",
+		ServedBy:     "envoy-gateway-7f9c6d5b8-x2xkq (eu-west-1, eu-west-1a)",
+
+		CorrelationIDs: [][2]string{{"x-correlation-id", "c0ffee00-1234-4abc-8def-0123456789ab"}},
+		NowUnix:        time.Now().Unix(),
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// validate lints error page templates with the same engine the plugin uses,
+// so broken templates are caught before they are built into the plugin. It
+// reports unknown placeholders, unbalanced conditionals, render failures and
+// pages larger than -max-size, and warns about external asset references and
+// theme variables missing from the manifest next to the template.
+//
+// Usage:
+//
+//	go run ./cmd/validate templates/my-theme.html
+//	go run ./cmd/validate -max-size 32768 templates/*.html
+//
+// The exit status is 1 if any template has errors.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/templates"
+)
+
+// templateError matches the location prefix of text/template errors
+var templateError = regexp.MustCompile(`^(?:failed to (?:parse|execute) template: )?template: errorpage:(\d+)(?::\d+)?: (?:executing "errorpage" at <[^>]*>: )?`)
+
+func main() {
+	maxSize := flag.Int("max-size", 64*1024, "maximum size of a rendered page in bytes")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] template.html...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		problems, warnings := validate(path, *maxSize)
+		for _, w := range warnings {
+			fmt.Printf("%s: warning: %s\n", path, w)
+		}
+		for _, p := range problems {
+			fmt.Printf("%s\n", p)
+		}
+		if len(problems) > 0 {
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: ok\n", path)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// validate parses the template at path and renders it for every known status
+// code, with and without request details
+func validate(path string, maxSize int) (problems, warnings []string) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	for _, ref := range errorpages.ExternalAssets(string(raw)) {
+		warnings = append(warnings, "external asset "+ref)
+	}
+
+	handler, err := errorpages.NewWithTemplate(raw, "validate")
+	if err != nil {
+		return []string{locate(path, err)}, warnings
+	}
+
+	manifest, err := os.ReadFile(strings.TrimSuffix(path, ".html") + ".json")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return []string{err.Error()}, warnings
+	}
+	theme, err := templates.NewTheme(strings.TrimSuffix(filepath.Base(path), ".html"), raw, manifest)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %v", path, err)}, warnings
+	}
+	for _, name := range handler.Variables() {
+		if _, ok := theme.Variables[name]; !ok {
+			warnings = append(warnings, fmt.Sprintf("variable %q is not declared in the manifest", name))
+		}
+	}
+	handler.SetVariableDefaults(theme.VariableDefaults())
+
+	largest, largestCode := 0, 0
+	for _, details := range []bool{true, false} {
+		for _, code := range errorpages.KnownStatusCodes() {
+			page, err := handler.RenderErrorPage(sampleData(code, details))
+			if err != nil {
+				return []string{locate(path, err)}, warnings
+			}
+			if len(page) > largest {
+				largest, largestCode = len(page), code
+			}
+		}
+	}
+	if largest > maxSize {
+		problems = append(problems, fmt.Sprintf("%s: rendered page for status %d is %d bytes, more than the %d byte limit", path, largestCode, largest, maxSize))
+	}
+	return problems, warnings
+}
+
+// locate rewrites a template error as path:line: message
+func locate(path string, err error) string {
+	msg := err.Error()
+	for unwrapped := errors.Unwrap(err); unwrapped != nil; unwrapped = errors.Unwrap(unwrapped) {
+		msg = unwrapped.Error()
+	}
+	if strings.HasSuffix(msg, "unexpected EOF") {
+		msg += " (unclosed {{ if }}, {{ range }} or {{ with }} block)"
+	}
+	if m := templateError.FindStringSubmatch(msg); m != nil {
+		return fmt.Sprintf("%s:%s: %s", path, m[1], msg[len(m[0]):])
+	}
+	return fmt.Sprintf("%s: %s", path, msg)
+}
+
+// sampleData fills every request detail with a long but realistic value, so
+// the size check covers the worst case
+func sampleData(code int, details bool) *errorpages.TemplateData {
+	return &errorpages.TemplateData{
+		Code:         code,
+		ShowDetails:  details,
+		Host:         "www.example.com",
+		OriginalURI:  "/checkout/cart?session=0123456789abcdef0123456789abcdef&step=2",
+		ForwardedFor: "203.0.113.10, 198.51.100.7, 10.0.0.1",
+		RequestID:    "3f2a9c1e-7b4d-4e8a-9c61-2d5f0a8b7e13",
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		Attempts:     3,
+		RequestSize:  2048,
+		ResponseSize: 1536,
+		TLSVersion:   "TLSv1.3",
+		PeerSAN:      "spiffe://cluster.local/ns/default/sa/checkout",
+		ServedBy:     "envoy-gateway-7f9c6d5b8-x2xkq (eu-west-1, eu-west-1a)",
+
+		CorrelationIDs: [][2]string{{"x-correlation-id", "c0ffee00-1234-4abc-8def-0123456789ab"}},
+		Nonce:          "c2FtcGxlbm9uY2UxMjM0NQ==",
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errorpages
+
+import (
+	"regexp"
+	"strings"
+)
+
+// External assets are references that make the browser fetch from another
+// host while rendering the page: src-like attributes, <link href>, CSS url()
+// and @import. Navigation links such as <a href> are not assets.
+var (
+	htmlTag        = regexp.MustCompile(`(?is)<([a-z][a-z0-9-]*)\b[^>]*>`)
+	externalAttr   = regexp.MustCompile(`(?is)\s(src|srcset|href|xlink:href|poster|data|background)\s*=\s*("\s*(?:https?:)?//[^"]*"|'\s*(?:https?:)?//[^']*')`)
+	externalURL    = regexp.MustCompile(`(?i)url\(\s*["']?\s*((?:https?:)?//[^)"']*)["']?\s*\)`)
+	externalImport = regexp.MustCompile(`(?i)@import\s+(?:url\()?\s*["']?\s*((?:https?:)?//[^)"';]*)["']?\s*\)?[^;]*;`)
+)
+
+// ExternalAssets returns the external asset references of a template
+func ExternalAssets(template string) []string {
+	var refs []string
+	for _, tag := range htmlTag.FindAllStringSubmatch(template, -1) {
+		for _, attr := range externalAttr.FindAllStringSubmatch(tag[0], -1) {
+			if isNavigation(tag[1], attr[1]) {
+				continue
+			}
+			refs = append(refs, strings.Trim(attr[2], `"' `))
+		}
+	}
+	for _, m := range externalImport.FindAllStringSubmatch(template, -1) {
+		refs = append(refs, m[1])
+	}
+	// imports may use url() themselves
+	for _, m := range externalURL.FindAllStringSubmatch(externalImport.ReplaceAllString(template, ""), -1) {
+		refs = append(refs, m[1])
+	}
+	return refs
+}
+
+// StripExternalAssets removes the external asset references of a template:
+// attributes are dropped, @import rules removed and url() values emptied, so
+// the page renders without any third-party request.
+func StripExternalAssets(template string) string {
+	template = htmlTag.ReplaceAllStringFunc(template, func(tag string) string {
+		name := strings.ToLower(htmlTag.FindStringSubmatch(tag)[1])
+		return externalAttr.ReplaceAllStringFunc(tag, func(attr string) string {
+			if isNavigation(name, externalAttr.FindStringSubmatch(attr)[1]) {
+				return attr
+			}
+			return ""
+		})
+	})
+	template = externalImport.ReplaceAllString(template, "")
+	return externalURL.ReplaceAllString(template, `url("data:,")`)
+}
+
+// isNavigation reports whether the attribute of a tag is a link the user
+// follows rather than an asset the browser loads
+func isNavigation(tag, attr string) bool {
+	if !strings.EqualFold(attr, "href") {
+		return false
+	}
+	switch strings.ToLower(tag) {
+	case "a", "area", "base":
+		return true
+	}
+	return false
+}
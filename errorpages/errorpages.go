@@ -0,0 +1,901 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package errorpages renders the error pages of the plugin. It has no
+// dependency on proxy-wasm, so plain Go HTTP services can serve the same
+// branded pages:
+//
+//	raw, err := themes.Get("cats")
+//	if err != nil { ... }
+//	pages, err := errorpages.NewWithTemplate(raw, version)
+//	if err != nil { ... }
+//
+//	page, err := pages.RenderErrorPage(&errorpages.TemplateData{Code: http.StatusServiceUnavailable})
+//	if err != nil { ... }
+//	w.Header().Set("content-type", "text/html; charset=utf-8")
+//	w.WriteHeader(http.StatusServiceUnavailable)
+//	w.Write(page)
+//
+// A Handler is not safe for concurrent use; guard it with a mutex or create
+// one per goroutine.
+package errorpages
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/qr"
+	"github.com/ishioni/envoy-wasm-error-pages/l10n"
+)
+
+// TemplateData holds all the data that can be used in error page templates
+type TemplateData struct {
+	Code         int               `token:"code"`
+	Message      string            `token:"message"`
+	Description  string            `token:"description"`
+	ShowDetails  bool              `token:"show_details"`
+	Host         string            `token:"host" escape:"html"`
+	OriginalURI  string            `token:"original_uri" escape:"html"`
+	RewrittenURI string            `token:"rewritten_uri" escape:"html"` // path sent upstream when Envoy rewrote original_uri
+	ForwardedFor string            `token:"forwarded_for" escape:"html"`
+	RequestID    string            `token:"request_id" escape:"html"`
+	TraceID      string            `token:"trace_id" escape:"html"`
+	SpanID       string            `token:"span_id" escape:"html"`
+	TLSVersion   string            `token:"tls_version" escape:"html"`  // downstream TLS version, e.g. TLSv1.3
+	PeerSubject  string            `token:"peer_subject" escape:"html"` // subject of the client certificate
+	PeerSAN      string            `token:"peer_san" escape:"html"`     // URI (e.g. SPIFFE ID) or DNS SAN of the client certificate
+	Attempts     int               `token:"attempt_count"`              // upstream attempts when Envoy retried, 0 otherwise
+	CodeDetails  string            `token:"code_details" escape:"html"` // Envoy's response code details, e.g. upstream_response_timeout
+	RequestSize  int64             `token:"request_size"`               // request body bytes
+	ResponseSize int64             `token:"response_size"`              // bytes of the upstream body the page replaced
+	NodeID       string            `token:"node_id" escape:"html"`      // id of the Envoy node serving the page
+	NodeCluster  string            `token:"node_cluster" escape:"html"`
+	NodeMetadata map[string]string // selected node metadata, registered as node_metadata
+	ServedBy     string            `token:"served_by" escape:"html"` // node id followed by the selected metadata, e.g. "gw-1 (eu-west-1)"
+	TitlePrefix  string            `token:"title_prefix" escape:"html"`
+	Favicon      string            `token:"favicon" escape:"html"` // data URI or URL of the page icon
+	OGImage      string            `token:"og_image" escape:"html"`
+	OGTitle      string            // link preview title, registered as og_title
+	NowUnix      int64             // registered as builtin function
+	L10nEnabled  bool              // registered as custom function
+	L10nScript   string            // registered as custom function
+	L10nBundle   string            // language switcher translations, registered as custom function
+	Nonce        string            // registered as custom function
+	Lang         string            // registered as custom function
+	Dir          string            // text direction, "ltr" or "rtl"; registered as custom function
+	DarkMode     string            `token:"dark_mode"` // "auto", "always" or "never"
+	Labels       map[string]string // translated labels, registered as t
+	Variables    map[string]string // theme variables, registered as var
+
+	// RetryInterval is the delay in seconds before pages of transient errors
+	// reload, DefaultRetryInterval when 0; negative values disable reloading.
+	// RetryCountdown leaves reloading to RetryCountdownSnippet instead of a
+	// meta refresh. See the retryable, meta_refresh and retry_interval
+	// functions.
+	RetryInterval  int
+	RetryCountdown bool
+
+	// Incident banner of 5xx pages, see IncidentBannerSnippet
+	IncidentMessage    string `token:"incident_message" escape:"html"`
+	IncidentURL        string `token:"incident_url" escape:"html"`
+	IncidentResolvesAt int64  // expected resolution, registered as incident_resolves_at
+
+	// Components are the affected components of the status page, registered
+	// as affected_components
+	Components []Component
+
+	// StatusURL links to the status or incident page, see StatusLinkSnippet
+	StatusURL string `token:"status_url" escape:"html"`
+
+	// Contact of the support team, see SupportSnippet. The mailto and ticket
+	// links are prefilled with the details of the request.
+	SupportEmail  string `token:"support_email" escape:"html"`
+	SupportMailto string `token:"support_mailto" escape:"html"`
+	SupportURL    string `token:"support_url" escape:"html"`
+	SupportPhone  string `token:"support_phone" escape:"html"`
+	SupportTel    string `token:"support_tel" escape:"html"`
+
+	// Script added by the custom_script placeholder, see CustomScriptSnippet
+	CustomScriptURL    string
+	CustomScriptInline string
+
+	// SupportReference is encoded in the QR code drawn by qr_code, e.g. the
+	// request ID or a support URL carrying it
+	SupportReference string
+
+	// Captured headers by lower-case name, registered as request_header(s)
+	// and response_header(s)
+	RequestHeaders  map[string]string
+	ResponseHeaders map[string]string
+
+	// CorrelationIDs are the names and values of the correlation headers of
+	// the request, registered as correlation_ids
+	CorrelationIDs [][2]string
+
+	// TimestampLayout and Location format NowUnix for the timestamp function
+	TimestampLayout string
+	Location        *time.Location
+
+	skeleton bool // render nowUnix and timestamp as sentinels, see RenderSkeleton
+}
+
+// Component is a component of the service that is not operational, as
+// reported by a status page
+type Component struct {
+	Name   string
+	Status string // English status label, e.g. "Partial outage"
+}
+
+// Localize sets the language of the page and fills in the strings of t,
+// which is nil for languages without a translation
+func (d *TemplateData) Localize(lang string, t *l10n.Translation) {
+	d.Lang = lang
+	d.Dir = l10n.Direction(lang)
+	if t == nil {
+		return
+	}
+	if msg, ok := t.Message(d.Code); ok {
+		d.Message = msg
+	}
+	if desc, ok := t.Description(d.Code); ok {
+		d.Description = desc
+	}
+	d.Labels = t.Labels
+	d.TimestampLayout = t.TimestampLayout
+}
+
+// SetStatusText replaces the message and description of the page with
+// custom texts, keeping translations of the status code in t. Empty texts
+// are ignored. Call it after Localize.
+func (d *TemplateData) SetStatusText(message, description string, t *l10n.Translation) {
+	translatedMessage, translatedDescription := t.Translates(d.Code)
+	if message != "" && !translatedMessage {
+		d.Message = message
+	}
+	if description != "" && !translatedDescription {
+		d.Description = description
+	}
+}
+
+// colorScheme returns the color-scheme meta value for the dark mode setting
+func (d *TemplateData) colorScheme() string {
+	switch d.DarkMode {
+	case "always":
+		return "dark"
+	case "never":
+		return "light"
+	}
+	return "light dark"
+}
+
+// darkModeMedia returns the media query that enables the dark variant of a
+// theme, so "always" and "never" override the visitor's preference
+func (d *TemplateData) darkModeMedia() string {
+	switch d.DarkMode {
+	case "always":
+		return "all"
+	case "never":
+		return "not all"
+	}
+	return "(prefers-color-scheme: dark)"
+}
+
+// DefaultRetryInterval is the delay in seconds before pages of transient
+// errors reload
+const DefaultRetryInterval = 30
+
+// retryableStatus holds the transient status codes whose pages reload
+var retryableStatus = map[int]bool{408: true, 425: true, 429: true, 500: true, 502: true, 503: true, 504: true}
+
+// retryable reports whether the page reloads automatically
+func (d *TemplateData) retryable() bool {
+	return d.RetryInterval > 0 && retryableStatus[d.Code]
+}
+
+// qrCode draws SupportReference as an inline SVG QR code, or returns an
+// empty string when it is unset or too long to encode
+func (d *TemplateData) qrCode() string {
+	if d.SupportReference == "" {
+		return ""
+	}
+	code, err := qr.Encode(d.SupportReference, qr.Medium)
+	if err != nil {
+		return ""
+	}
+	label := "Scan for the support reference"
+	if translated, ok := d.Labels[label]; ok {
+		label = translated
+	}
+	return code.SVG(`class="error-pages-qr-code" role="img" aria-label="` + html.EscapeString(label) + `"`)
+}
+
+// formatBytes implements the bytes function, which prints a size in bytes
+// with a binary unit, e.g. 1.5 KiB
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	value, unit := float64(n)/1024, 0
+	for value >= 1024 && unit < 3 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + [...]string{"KiB", "MiB", "GiB", "TiB"}[unit]
+}
+
+// DefaultTimestampLayout formats timestamps of pages without a localized
+// layout
+const DefaultTimestampLayout = "Jan 2, 2006, 3:04:05 PM MST"
+
+// timestamp formats NowUnix with the page's layout and location
+func (d *TemplateData) timestamp() string {
+	return d.formatTime(d.NowUnix)
+}
+
+// formatTime formats a Unix time with the page's layout and location
+func (d *TemplateData) formatTime(unix int64) string {
+	return time.Unix(unix, 0).In(d.Location).Format(d.TimestampLayout)
+}
+
+// Values converts TemplateData fields into a map keyed by their token tags,
+// suitable for registering as template functions.
+func (d *TemplateData) Values() map[string]any {
+	result := make(map[string]any)
+	v := reflect.ValueOf(*d)
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		if token, ok := t.Field(i).Tag.Lookup("token"); ok {
+			result[token] = v.Field(i).Interface()
+		}
+	}
+	return result
+}
+
+// Handler manages error page templates and detection
+type Handler struct {
+	templateText string             // preprocessed template content
+	snippets     []string           // templates injected before </body>
+	tmpl         *template.Template // compiled page with snippet slots
+	data         *TemplateData      // data of the page being rendered
+	cache        map[pageKey][]byte // pre-rendered pages
+	defaults     map[string]string  // theme variable defaults, see var
+	stylesheets  map[int]string     // stylesheet URLs by status code, see UseStylesheets
+	version      string
+}
+
+// NewWithTemplate creates a handler that uses a Go template for error pages
+func NewWithTemplate(templateBytes []byte, version string) (*Handler, error) {
+	h := &Handler{
+		templateText: addNonceAttributes(preprocessTemplate(string(templateBytes))),
+		version:      version,
+	}
+	if err := h.compile(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// SetVariableDefaults sets the values of theme variables the page data
+// doesn't set, usually the defaults declared in the theme's manifest
+func (h *Handler) SetVariableDefaults(defaults map[string]string) {
+	h.defaults = defaults
+}
+
+// AddSnippet registers a template fragment that is rendered with the same
+// data and functions as the page and injected right before </body>
+func (h *Handler) AddSnippet(snippet string) error {
+	h.snippets = append(h.snippets, snippet)
+	if err := h.compile(); err != nil {
+		h.snippets = h.snippets[:len(h.snippets)-1]
+		return err
+	}
+	return nil
+}
+
+// compile parses the page template once, with a slot for every snippet
+// spliced in before </body>, so rendering is a single execution pass.
+// Template functions read from h.data, which is set for the duration of a
+// render; proxy-wasm VMs are single-threaded, so this needs no locking.
+func (h *Handler) compile() error {
+	text := h.templateText
+	if len(h.snippets) > 0 {
+		var slots strings.Builder
+		for i := range h.snippets {
+			slots.WriteString(`{{ template "snippet` + strconv.Itoa(i) + `" . }}`)
+		}
+		text = injectBeforeBodyEnd(text, slots.String())
+	}
+
+	tmpl, err := template.New("errorpage").Funcs(h.funcs()).Parse(text)
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	for i, snippet := range h.snippets {
+		if _, err := tmpl.New("snippet" + strconv.Itoa(i)).Parse(snippet); err != nil {
+			return fmt.Errorf("failed to parse snippet: %w", err)
+		}
+	}
+	h.tmpl = tmpl
+	return nil
+}
+
+// funcs builds the template functions. Every TemplateData field with a token
+// tag is registered under its token; fields tagged escape:"html" hold
+// request-derived values and print HTML-escaped.
+func (h *Handler) funcs() template.FuncMap {
+	fns := template.FuncMap{
+		"escape": escapeHTML,
+		"raw":    h.raw,
+		"js":     h.js,
+		"nowUnix": func() string {
+			if h.data.skeleton {
+				return sentinelNowUnix
+			}
+			return strconv.FormatInt(h.data.NowUnix, 10)
+		},
+		"timestamp": func() string {
+			if h.data.skeleton {
+				return sentinelTimestamp
+			}
+			return h.data.timestamp()
+		},
+		"l10n_enabled":  func() bool { return h.data.L10nEnabled },
+		"l10nScript":    func() string { return h.data.L10nScript },
+		"l10nBundle":    func() string { return h.data.L10nBundle },
+		"namespace":     func() string { return "" },
+		"nonce":         h.nonce,
+		"custom_script": h.customScript,
+		"lang":          func() string { return h.data.Lang },
+		"dir":           func() string { return h.data.Dir },
+		"og_title": func() any {
+			if h.data.OGTitle != "" {
+				return requestValue(h.data.OGTitle)
+			}
+			return requestValue(h.data.TitlePrefix + strconv.Itoa(h.data.Code) + ": " + h.data.Message)
+		},
+		"incident_resolves_at": func() string {
+			if h.data.IncidentResolvesAt == 0 {
+				return ""
+			}
+			return h.data.formatTime(h.data.IncidentResolvesAt)
+		},
+		"stylesheet":      func() string { return h.stylesheets[h.data.Code] },
+		"color_scheme":    func() string { return h.data.colorScheme() },
+		"dark_mode_media": func() string { return h.data.darkModeMedia() },
+		"var": func(name string, fallback ...string) string {
+			if value := h.data.Variables[name]; value != "" {
+				return value
+			}
+			if value := h.defaults[name]; value != "" {
+				return value
+			}
+			return strings.Join(fallback, "")
+		},
+		"request_header": func(name string) any {
+			return requestValue(h.data.RequestHeaders[strings.ToLower(name)])
+		},
+		"response_header": func(name string) any {
+			return requestValue(h.data.ResponseHeaders[strings.ToLower(name)])
+		},
+		"request_headers": func() map[string]requestValue { return requestValues(h.data.RequestHeaders) },
+		"affected_components": func() []affectedComponent {
+			components := make([]affectedComponent, len(h.data.Components))
+			for i, c := range h.data.Components {
+				components[i] = affectedComponent{Name: requestValue(c.Name), Status: c.Status}
+			}
+			return components
+		},
+		"correlation_ids": func() []correlationID {
+			ids := make([]correlationID, len(h.data.CorrelationIDs))
+			for i, id := range h.data.CorrelationIDs {
+				ids[i] = correlationID{Name: id[0], Value: requestValue(id[1])}
+			}
+			return ids
+		},
+		"response_headers": func() map[string]requestValue { return requestValues(h.data.ResponseHeaders) },
+		"code_details_explanation": func() string {
+			explanation := ExplainCodeDetails(h.data.CodeDetails)
+			if translated, ok := h.data.Labels[explanation]; ok {
+				return translated
+			}
+			return explanation
+		},
+		"bytes":          h.formatBytes,
+		"retryable":      func() bool { return h.data.retryable() },
+		"meta_refresh":   func() bool { return h.data.retryable() && !h.data.RetryCountdown },
+		"retry_interval": func() int { return h.data.RetryInterval },
+		"qr_code": func() string {
+			if h.data.skeleton && h.data.SupportReference != "" {
+				return sentinelQRCode
+			}
+			return h.data.qrCode()
+		},
+		"node_metadata": func(key string) any {
+			return requestValue(h.data.NodeMetadata[key])
+		},
+		"t": func(label string) string {
+			if translated, ok := h.data.Labels[label]; ok {
+				return translated
+			}
+			return label
+		},
+	}
+
+	t := reflect.TypeOf(TemplateData{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		token, ok := field.Tag.Lookup("token")
+		if !ok {
+			continue
+		}
+		idx := i
+		if field.Tag.Get("escape") == "html" {
+			fns[token] = func() any { return requestValue(reflect.ValueOf(h.data).Elem().Field(idx).String()) }
+		} else {
+			fns[token] = func() any { return reflect.ValueOf(h.data).Elem().Field(idx).Interface() }
+		}
+	}
+	return fns
+}
+
+// formatBytes implements the bytes function, which formats a byte count
+func (h *Handler) formatBytes(n int64) string {
+	if h.data.skeleton {
+		switch n {
+		case sentinelRequestSize:
+			return sentinelRequestSizeBytes
+		case sentinelResponseSize:
+			return sentinelResponseSizeBytes
+		}
+	}
+	return formatBytes(n)
+}
+
+// nonce implements the nonce function, the CSP nonce of the response
+func (h *Handler) nonce() string {
+	if h.data.skeleton && h.data.Nonce != "" {
+		return sentinelNonce
+	}
+	return h.data.Nonce
+}
+
+// pageKey identifies a pre-rendered page
+type pageKey struct {
+	code int
+	lang string
+}
+
+// Prerender renders the page for every status code of codes in every
+// language without request details and caches the result. localize fills in the
+// language-specific data. Only use it when pages depend on nothing but the
+// status code and language.
+func (h *Handler) Prerender(codes []int, langs []string, localize func(data *TemplateData, lang string)) error {
+	cache := make(map[pageKey][]byte)
+	for _, lang := range langs {
+		for _, code := range codes {
+			data := &TemplateData{Code: code}
+			localize(data, lang)
+			page, err := h.RenderErrorPage(data)
+			if err != nil {
+				return fmt.Errorf("failed to pre-render status %d (%s): %w", code, lang, err)
+			}
+			cache[pageKey{code, lang}] = page
+		}
+	}
+	h.cache = cache
+	return nil
+}
+
+// CachedPage returns the pre-rendered page for a status code and language,
+// if any
+func (h *Handler) CachedPage(code int, lang string) ([]byte, bool) {
+	page, ok := h.cache[pageKey{code, lang}]
+	return page, ok
+}
+
+// IsErrorStatus checks if a status code is in the 4xx or 5xx range
+func IsErrorStatus(status string) bool {
+	if len(status) != 3 {
+		return false
+	}
+	return status[0] == '4' || status[0] == '5'
+}
+
+// RenderErrorPage renders the template with the provided data
+func (h *Handler) RenderErrorPage(data *TemplateData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := h.RenderTo(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderTo renders the template with the provided data into buf, which is
+// reset first. Reusing buf across renders avoids allocating a fresh page for
+// every response.
+func (h *Handler) RenderTo(buf *bytes.Buffer, data *TemplateData) error {
+	if data.NowUnix == 0 {
+		data.NowUnix = time.Now().Unix()
+	}
+	if data.Message == "" {
+		data.Message = getStatusMessage(data.Code)
+	}
+	if data.Description == "" {
+		data.Description = getStatusDescription(data.Code)
+	}
+	if data.Lang == "" {
+		data.Lang = "en"
+	}
+	if data.Dir == "" {
+		data.Dir = "ltr"
+	}
+	if data.DarkMode == "" {
+		data.DarkMode = "auto"
+	}
+	if data.TimestampLayout == "" {
+		data.TimestampLayout = DefaultTimestampLayout
+	}
+	if data.Location == nil {
+		data.Location = time.UTC
+	}
+	if data.RetryInterval == 0 {
+		data.RetryInterval = DefaultRetryInterval
+	}
+
+	h.data = data
+	defer func() { h.data = nil }()
+
+	buf.Reset()
+	buf.Grow(len(h.templateText) + len(h.templateText)/8)
+	if err := h.tmpl.Execute(buf, data); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+	return nil
+}
+
+// injectBeforeBodyEnd inserts content right before the closing body tag, or
+// appends it when the page has none.
+func injectBeforeBodyEnd(page, content string) string {
+	idx := strings.LastIndex(strings.ToLower(page), "</body>")
+	if idx == -1 {
+		return page + content
+	}
+	return page[:idx] + content + page[idx:]
+}
+
+// preprocessTemplate strips HTML/CSS/JS comment wrappers around Go template
+// directives so that text/template can parse them natively. Value expressions
+// like // {{ l10nScript }} are left untouched.
+func preprocessTemplate(raw string) string {
+	lines := strings.Split(raw, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		// HTML comment wrapper: <!-- {{...}} -->
+		if strings.HasPrefix(trimmed, "<!--") && strings.HasSuffix(trimmed, "-->") {
+			inner := strings.TrimPrefix(trimmed, "<!--")
+			inner = strings.TrimSuffix(inner, "-->")
+			inner = strings.TrimSpace(inner)
+			if containsOnlyDirectives(inner) {
+				lines[i] = ensureOuterTrimMarkers(inner)
+				continue
+			}
+		}
+
+		// CSS comment wrapper: /* {{...}} */
+		if strings.HasPrefix(trimmed, "/*") && strings.HasSuffix(trimmed, "*/") {
+			inner := strings.TrimPrefix(trimmed, "/*")
+			inner = strings.TrimSuffix(inner, "*/")
+			inner = strings.TrimSpace(inner)
+			if containsOnlyDirectives(inner) {
+				lines[i] = ensureOuterTrimMarkers(inner)
+				continue
+			}
+		}
+
+		// JS line comment wrapper: // {{...}}
+		if strings.HasPrefix(trimmed, "//") && !strings.HasPrefix(trimmed, "///") {
+			inner := strings.TrimPrefix(trimmed, "//")
+			inner = strings.TrimSpace(inner)
+			if containsOnlyDirectives(inner) {
+				lines[i] = ensureOuterTrimMarkers(inner)
+				continue
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// inlineTagOpen matches the opening tag of inline scripts and styles
+var inlineTagOpen = regexp.MustCompile(`(?i)<(script|style)\b[^>]*>`)
+
+// imageSource matches the source of img tags referencing absolute URLs
+var imageSource = regexp.MustCompile(`(?i)<img\b[^>]*\bsrc="(https?://[^"]+)"`)
+
+// ImageURLs returns the absolute image URLs referenced by the template, which
+// may still contain template actions
+func (h *Handler) ImageURLs() []string {
+	var urls []string
+	for _, m := range imageSource.FindAllStringSubmatch(h.templateText, -1) {
+		urls = append(urls, m[1])
+	}
+	return urls
+}
+
+// addNonceAttributes gives every script and style tag that has no nonce yet
+// the per-response CSP nonce, when one is set
+func addNonceAttributes(text string) string {
+	return inlineTagOpen.ReplaceAllStringFunc(text, func(tag string) string {
+		if strings.Contains(strings.ToLower(tag), "nonce") {
+			return tag
+		}
+		name := len("<script")
+		if strings.EqualFold(tag[1:6], "style") {
+			name = len("<style")
+		}
+		return tag[:name] + `{{ if nonce }} nonce="{{ nonce }}"{{ end }}` + tag[name:]
+	})
+}
+
+// containsOnlyDirectives checks whether s consists entirely of Go template
+// actions ({{ ... }}) containing control-flow keywords, with only whitespace
+// between them.
+func containsOnlyDirectives(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+
+	remaining := s
+	found := false
+	for len(remaining) > 0 {
+		idx := strings.Index(remaining, "{{")
+		if idx == -1 {
+			return found && strings.TrimSpace(remaining) == ""
+		}
+		if strings.TrimSpace(remaining[:idx]) != "" {
+			return false
+		}
+		endIdx := strings.Index(remaining[idx:], "}}")
+		if endIdx == -1 {
+			return false
+		}
+
+		action := remaining[idx+2 : idx+endIdx]
+		action = strings.Trim(action, "- ")
+		action = strings.TrimSpace(action)
+
+		if !isControlKeyword(action) {
+			return false
+		}
+
+		remaining = remaining[idx+endIdx+2:]
+		found = true
+	}
+	return found
+}
+
+var controlKeywords = []string{"if ", "else if ", "else", "end", "range ", "with ", "block ", "define ", "template "}
+
+func isControlKeyword(action string) bool {
+	for _, kw := range controlKeywords {
+		if action == strings.TrimSpace(kw) || strings.HasPrefix(action, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureOuterTrimMarkers ensures the first {{ and last }} in the string have
+// whitespace-trimming markers ({{- and -}}).
+func ensureOuterTrimMarkers(s string) string {
+	if strings.HasPrefix(s, "{{") && !strings.HasPrefix(s, "{{-") {
+		s = "{{- " + strings.TrimLeft(s[2:], " ")
+	}
+	if strings.HasSuffix(s, "}}") && !strings.HasSuffix(s, "-}}") {
+		s = strings.TrimRight(s[:len(s)-2], " ") + " -}}"
+	}
+	return s
+}
+
+// statusMessages maps HTTP status codes to their standard messages
+var statusMessages = map[int]string{
+	// 4xx Client Errors
+	400: "Bad Request",
+	401: "Unauthorized",
+	402: "Payment Required",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	409: "Conflict",
+	410: "Gone",
+	411: "Length Required",
+	412: "Precondition Failed",
+	413: "Payload Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	417: "Expectation Failed",
+	418: "I'm a teapot",
+	421: "Misdirected Request",
+	422: "Unprocessable Entity",
+	423: "Locked",
+	424: "Failed Dependency",
+	425: "Too Early",
+	426: "Upgrade Required",
+	428: "Precondition Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	451: "Unavailable For Legal Reasons",
+
+	// 5xx Server Errors
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+	505: "HTTP Version Not Supported",
+	506: "Variant Also Negotiates",
+	507: "Insufficient Storage",
+	508: "Loop Detected",
+	510: "Not Extended",
+	511: "Network Authentication Required",
+}
+
+// StatusMessage returns the built-in English message for a status code
+func StatusMessage(code int) string {
+	return getStatusMessage(code)
+}
+
+// StatusDescription returns the built-in English description for a status
+// code
+func StatusDescription(code int) string {
+	return getStatusDescription(code)
+}
+
+// getStatusMessage returns the standard HTTP status message for a code
+func getStatusMessage(code int) string {
+	if code >= 400 && code < 600 {
+		return messageTable[code-400]
+	}
+	return lookupStatus(statusMessages, code, clientErrorMessage, serverErrorMessage)
+}
+
+// IsKnownStatus reports whether a status code has a standard message
+func IsKnownStatus(code int) bool {
+	_, ok := statusMessages[code]
+	return ok
+}
+
+// KnownStatusCodes returns the status codes that have a standard message,
+// in ascending order
+func KnownStatusCodes() []int {
+	codes := make([]int, 0, len(statusMessages))
+	for code := range statusMessages {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// StatusCodes returns the known status codes and extra, in ascending order
+// without duplicates
+func StatusCodes(extra []int) []int {
+	codes := append(KnownStatusCodes(), extra...)
+	sort.Ints(codes)
+	return slices.Compact(codes)
+}
+
+// codeDetailsExplanations explains common Envoy response code details in
+// plain language. The explanations double as translation labels.
+var codeDetailsExplanations = map[string]string{
+	"upstream_reset_before_response_started": "The upstream server closed the connection before responding.",
+	"upstream_reset_after_response_started":  "The upstream server closed the connection while responding.",
+	"upstream_response_timeout":              "The upstream server did not respond in time.",
+	"upstream_per_try_timeout":               "An attempt to reach the upstream server timed out.",
+	"upstream_max_stream_duration_reached":   "The request took longer than the upstream server allows.",
+	"no_healthy_upstream":                    "No healthy upstream server is available.",
+	"cluster_not_found":                      "The service for this address is not configured.",
+	"route_not_found":                        "No route matches this address.",
+	"maintenance_mode":                       "The service is down for maintenance.",
+	"request_overall_timeout":                "The request took too long to complete.",
+	"request_payload_too_large":              "The request is too large.",
+	"response_payload_too_large":             "The response is too large to deliver.",
+	"ext_authz_denied":                       "The request was denied by the authorization service.",
+	"ext_authz_error":                        "The authorization service could not be reached.",
+	"rbac_access_denied_matched_policy":      "The request was denied by an access policy.",
+	"request_rate_limited":                   "Too many requests were sent in a given amount of time.",
+	"local_rate_limited":                     "Too many requests were sent in a given amount of time.",
+}
+
+// ExplainCodeDetails returns the plain-language explanation of Envoy
+// response code details, or "" for unknown details. Qualifiers such as the
+// reset reason in upstream_reset_before_response_started{remote_reset} or
+// the policy in rbac_access_denied_matched_policy[none] are ignored.
+func ExplainCodeDetails(details string) string {
+	if i := strings.IndexAny(details, "{["); i >= 0 {
+		details = details[:i]
+	}
+	return codeDetailsExplanations[details]
+}
+
+// statusDescriptions maps common HTTP status codes to a description
+var statusDescriptions = map[int]string{
+	400: "The request could not be understood by the server due to malformed syntax.",
+	401: "The request requires user authentication.",
+	403: "The server understood the request, but is refusing to fulfill it.",
+	404: "The requested resource could not be found.",
+	405: "The method specified in the request is not allowed for the resource.",
+	408: "The server timed out waiting for the request.",
+	429: "Too many requests have been sent in a given amount of time.",
+	500: "The server encountered an unexpected condition that prevented it from fulfilling the request.",
+	502: "The server received an invalid response from the upstream server.",
+	503: "The server is currently unable to handle the request due to temporary overloading or maintenance.",
+	504: "The server did not receive a timely response from the upstream server.",
+}
+
+// Fallbacks for codes missing from the tables above
+const (
+	clientErrorMessage     = "Client Error"
+	serverErrorMessage     = "Server Error"
+	clientErrorDescription = "An error occurred while processing your request."
+	serverErrorDescription = "The server encountered an error while processing your request."
+)
+
+// Error status codes (4xx and 5xx) index directly into these tables, with
+// the generic class fallbacks already applied, so lookups on the hot path
+// are a bounds check and an array read.
+var (
+	messageTable     [200]string
+	descriptionTable [200]string
+)
+
+func init() {
+	for i := range messageTable {
+		code := 400 + i
+		messageTable[i] = lookupStatus(statusMessages, code, clientErrorMessage, serverErrorMessage)
+		descriptionTable[i] = lookupStatus(statusDescriptions, code, clientErrorDescription, serverErrorDescription)
+	}
+}
+
+// lookupStatus returns the table entry for code, or the 4xx/5xx fallback
+func lookupStatus(table map[int]string, code int, clientFallback, serverFallback string) string {
+	if v, ok := table[code]; ok {
+		return v
+	}
+	if code >= 400 && code < 500 {
+		return clientFallback
+	}
+	return serverFallback
+}
+
+// getStatusDescription returns a description for common HTTP status codes
+func getStatusDescription(code int) string {
+	if code >= 400 && code < 600 {
+		return descriptionTable[code-400]
+	}
+	return lookupStatus(statusDescriptions, code, clientErrorDescription, serverErrorDescription)
+}
@@ -0,0 +1,100 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errorpages
+
+import (
+	"fmt"
+	"html"
+	"text/template"
+)
+
+// requestValue is a request-derived template value, such as the host or the
+// original URI. It prints HTML-escaped; templates pipe it through raw to
+// print it verbatim, or through js for script contexts.
+type requestValue string
+
+// String implements fmt.Stringer, which text/template uses when printing.
+func (v requestValue) String() string {
+	return html.EscapeString(string(v))
+}
+
+// escapeHTML implements the escape function. Request values are escaped
+// once, whether or not they are piped through escape.
+func escapeHTML(v any) string {
+	if rv, ok := v.(requestValue); ok {
+		return rv.String()
+	}
+	return html.EscapeString(fmt.Sprint(v))
+}
+
+// raw implements the raw function, which prints a request value verbatim.
+// In skeletons it switches to the raw variant of the sentinel.
+func (h *Handler) raw(v any) string {
+	rv, ok := v.(requestValue)
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	if h.data.skeleton {
+		if sentinel, ok := rawSentinel(string(rv)); ok {
+			return sentinel
+		}
+	}
+	return string(rv)
+}
+
+// js implements the js function. It replaces the builtin so request values
+// are escaped for JavaScript from their raw value instead of their HTML
+// form. In skeletons it switches to the js variant of the sentinel.
+func (h *Handler) js(args ...any) string {
+	for i, arg := range args {
+		if rv, ok := arg.(requestValue); ok {
+			args[i] = string(rv)
+		}
+	}
+	if h.data.skeleton && len(args) == 1 {
+		if s, ok := args[0].(string); ok {
+			if sentinel, ok := jsSentinel(s); ok {
+				return sentinel
+			}
+		}
+	}
+	return template.JSEscaper(args...)
+}
+
+// correlationID is a correlation header of the request, ranged over by
+// templates as {{ range correlation_ids }}{{ .Name }}: {{ .Value }}{{ end }}
+type correlationID struct {
+	Name  string
+	Value requestValue
+}
+
+// requestValues wraps the values of a map of request-derived values, such as
+// captured headers, so ranging over it prints them escaped
+func requestValues(values map[string]string) map[string]requestValue {
+	wrapped := make(map[string]requestValue, len(values))
+	for k, v := range values {
+		wrapped[k] = requestValue(v)
+	}
+	return wrapped
+}
+
+// affectedComponent is a component of the status page that isn't
+// operational, ranged over by templates as
+// {{ range affected_components }}{{ .Name }}: {{ t .Status }}{{ end }}. The
+// name comes from the status API and prints escaped like request values.
+type affectedComponent struct {
+	Name   requestValue
+	Status string // English status label, e.g. "Partial outage"
+}
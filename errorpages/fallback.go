@@ -0,0 +1,39 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errorpages
+
+import (
+	"html"
+	"strconv"
+
+	"github.com/ishioni/envoy-wasm-error-pages/l10n"
+)
+
+// FallbackPage returns a minimal page with the status code and message,
+// served when a theme fails to render. It doesn't use templates, styles or
+// scripts, so it can't fail itself and is readable under any CSP. An empty
+// message uses the built-in English one.
+func FallbackPage(code int, message, lang string) []byte {
+	if message == "" {
+		message = StatusMessage(code)
+	}
+	if lang == "" {
+		lang = "en"
+	}
+	title := strconv.Itoa(code) + " " + html.EscapeString(message)
+	return []byte(`<!DOCTYPE html><html lang="` + html.EscapeString(lang) + `" dir="` + l10n.Direction(lang) + `"><head><meta charset="utf-8">` +
+		`<meta name="viewport" content="width=device-width, initial-scale=1"><meta name="color-scheme" content="light dark"><meta name="robots" content="noindex">` +
+		`<title>` + title + `</title></head><body><h1>` + title + `</h1></body></html>`)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errorpages
+
+import (
+	"sort"
+	"text/template/parse"
+)
+
+// notPlaceholders are functions themes don't use to show values: filters,
+// and nonce, which is added to inline scripts and styles automatically
+var notPlaceholders = map[string]bool{"escape": true, "raw": true, "js": true, "nonce": true}
+
+// Placeholders returns the names of the placeholders the page template uses,
+// such as code, message or request_id, in alphabetical order. Filters like
+// escape and text/template builtins are not included.
+func (h *Handler) Placeholders() []string {
+	fns := h.funcs()
+	used := make(map[string]bool)
+	h.walk(func(node parse.Node) {
+		if n, ok := node.(*parse.IdentifierNode); ok {
+			if _, ok := fns[n.Ident]; ok && !notPlaceholders[n.Ident] {
+				used[n.Ident] = true
+			}
+		}
+	})
+	return sortedKeys(used)
+}
+
+// Variables returns the names of the theme variables the page template reads
+// with var, in alphabetical order
+func (h *Handler) Variables() []string {
+	used := make(map[string]bool)
+	h.walk(func(node parse.Node) {
+		cmd, ok := node.(*parse.CommandNode)
+		if !ok || len(cmd.Args) < 2 {
+			return
+		}
+		if fn, ok := cmd.Args[0].(*parse.IdentifierNode); ok && fn.Ident == "var" {
+			if name, ok := cmd.Args[1].(*parse.StringNode); ok {
+				used[name.Text] = true
+			}
+		}
+	})
+	return sortedKeys(used)
+}
+
+// walk calls visit for every node of the page template
+func (h *Handler) walk(visit func(node parse.Node)) {
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		visit(node)
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(&n.BranchNode)
+		case *parse.RangeNode:
+			walk(&n.BranchNode)
+		case *parse.WithNode:
+			walk(&n.BranchNode)
+		case *parse.BranchNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(h.tmpl.Tree.Root)
+}
+
+// sortedKeys returns the keys of set in alphabetical order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,37 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errorpages
+
+import "regexp"
+
+var (
+	noIndexMeta = regexp.MustCompile(`(?i)<meta\s[^>]*(name\s*=\s*["']?robots\b[^>]*noindex|noindex[^>]*name\s*=\s*["']?robots\b)`)
+	headOpen    = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+)
+
+// NoIndex inserts a robots meta tag with noindex at the top of the head of a
+// template that doesn't already carry one. Crawlers apply the most
+// restrictive of several robots tags, so a template asking to be indexed is
+// overruled. ok is false when the template has no head to insert it into.
+func NoIndex(template string) (_ string, ok bool) {
+	if noIndexMeta.MatchString(template) {
+		return template, true
+	}
+	loc := headOpen.FindStringIndex(template)
+	if loc == nil {
+		return template, false
+	}
+	return template[:loc[1]] + `<meta name="robots" content="noindex" />` + template[loc[1]:], true
+}
@@ -0,0 +1,339 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errorpages
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Skeletons are pages rendered with every per-request value replaced by a
+// sentinel, so a single render can be shared between requests for the same
+// status code and host and filled in cheaply afterwards. The sentinels only
+// use characters that survive the escape and js template filters unchanged.
+// Request values have raw and js variants of their sentinel, so each
+// occurrence is filled with the escaping of its context.
+//
+// Sentinels embed a random token, so that values printed as is, such as the
+// host, cannot carry a sentinel to be filled with an unescaped value.
+var (
+	sentinelOriginalURI  string
+	sentinelRewrittenURI string
+	sentinelForwardedFor string
+	sentinelRequestID    string
+	sentinelTraceID      string
+	sentinelSpanID       string
+	sentinelTLSVersion   string
+	sentinelPeerSubject  string
+	sentinelPeerSAN      string
+	sentinelStatusURL    string
+	sentinelSupportMail  string
+	sentinelSupportURL   string
+	sentinelNowUnix      string
+	sentinelTimestamp    string
+	sentinelNonce        string
+	sentinelQRCode       string
+
+	sentinelRequestSizeBytes  string
+	sentinelResponseSizeBytes string
+)
+
+// Body sizes are numbers, so a skeleton renders them as negative sizes no
+// request has. They survive every filter unchanged, and the bytes function
+// turns them into the string sentinels above.
+const (
+	sentinelRequestSize  int64 = -7314159265001
+	sentinelResponseSize int64 = -7314159265002
+)
+
+// skeletonToken is the token embedded in sentinels, see SetSkeletonToken
+var skeletonToken string
+
+func init() {
+	setSentinels(rand.Text())
+}
+
+// SkeletonToken returns the token embedded in skeleton sentinels. It is
+// random unless set with SetSkeletonToken.
+func SkeletonToken() string {
+	return skeletonToken
+}
+
+// SetSkeletonToken sets the token embedded in skeleton sentinels, so that
+// processes sharing skeletons can fill each other's. The token must be an
+// unguessable string of ASCII letters and digits. Set it before rendering.
+func SetSkeletonToken(token string) error {
+	if token == "" {
+		return errors.New("skeleton token must not be empty")
+	}
+	for _, c := range token {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return fmt.Errorf("invalid skeleton token %q: must only contain ASCII letters and digits", token)
+		}
+	}
+	setSentinels(token)
+	return nil
+}
+
+func setSentinels(token string) {
+	skeletonToken = token
+	sentinel := func(name string) string {
+		return "__errorpages_" + token + "_" + name + "__"
+	}
+	sentinelOriginalURI = valueSentinel("original_uri")
+	sentinelRewrittenURI = valueSentinel("rewritten_uri")
+	sentinelForwardedFor = valueSentinel("forwarded_for")
+	sentinelRequestID = valueSentinel("request_id")
+	sentinelTraceID = valueSentinel("trace_id")
+	sentinelSpanID = valueSentinel("span_id")
+	sentinelTLSVersion = valueSentinel("tls_version")
+	sentinelPeerSubject = valueSentinel("peer_subject")
+	sentinelPeerSAN = valueSentinel("peer_san")
+	sentinelStatusURL = valueSentinel("status_url")
+	sentinelSupportMail = valueSentinel("support_mailto")
+	sentinelSupportURL = valueSentinel("support_url")
+	sentinelNowUnix = sentinel("now_unix")
+	sentinelTimestamp = sentinel("timestamp")
+	sentinelNonce = sentinel("nonce")
+	sentinelQRCode = sentinel("qr_code")
+	sentinelRequestSizeBytes = sentinel("request_size_bytes")
+	sentinelResponseSizeBytes = sentinel("response_size_bytes")
+}
+
+// valueSentinel returns the sentinel of a request value. Their names start
+// with value_, so raw and js can tell them from the other sentinels.
+func valueSentinel(name string) string {
+	return "__errorpages_" + skeletonToken + "_value_" + name + "__"
+}
+
+// rawSentinel returns the raw variant of the sentinel of a request value
+func rawSentinel(s string) (string, bool) {
+	name, ok := strings.CutPrefix(s, "__errorpages_")
+	if !ok || !strings.HasPrefix(name, skeletonToken+"_value_") {
+		return "", false
+	}
+	return "__errorpages_raw_" + name, true
+}
+
+// jsSentinel returns the js variant of the sentinel of a request value or of
+// its raw variant
+func jsSentinel(s string) (string, bool) {
+	name, ok := strings.CutPrefix(s, "__errorpages_raw_")
+	if !ok {
+		name, ok = strings.CutPrefix(s, "__errorpages_")
+	}
+	if !ok || !strings.HasPrefix(name, skeletonToken+"_value_") {
+		return "", false
+	}
+	return "__errorpages_js_" + name, true
+}
+
+// SkeletonKey identifies the skeleton a request can share: the sentinel
+// token, the status code, the language and whether the l10n client script is
+// included, the host, whether details are shown and which per-request values,
+// including the support reference, links and body sizes, are present, since
+// templates branch on their presence. So are the names of captured headers
+// and correlation IDs, which templates range over. The attempt count and
+// response code details are part of the key, since they are printed as is.
+func SkeletonKey(data *TemplateData) string {
+	values := []string{data.OriginalURI, data.RewrittenURI, data.ForwardedFor, data.RequestID, data.TraceID, data.SpanID, data.TLSVersion, data.PeerSubject, data.PeerSAN, data.SupportReference, data.StatusURL, data.SupportMailto, data.SupportURL}
+	mask := 0
+	for i, v := range values {
+		if v != "" {
+			mask |= 1 << i
+		}
+	}
+	if data.ShowDetails {
+		mask |= 1 << len(values)
+	}
+	// Pages with a language hint skip the client script
+	if data.L10nEnabled {
+		mask |= 1 << (len(values) + 1)
+	}
+	if data.RequestSize != 0 {
+		mask |= 1 << (len(values) + 2)
+	}
+	if data.ResponseSize != 0 {
+		mask |= 1 << (len(values) + 3)
+	}
+	return skeletonToken + "|" + strconv.Itoa(data.Code) + "|" + data.Lang + "|" + strconv.Itoa(mask) + "|" + strconv.Itoa(data.Attempts) + "|" + data.CodeDetails + "|" + data.Host +
+		headersKey(data.RequestHeaders) + headersKey(data.ResponseHeaders) + correlationKey(data.CorrelationIDs)
+}
+
+// RenderSkeleton renders the page for data with per-request values replaced
+// by sentinels. Fill the result with FillSkeleton before serving it.
+func (h *Handler) RenderSkeleton(data *TemplateData) ([]byte, error) {
+	skeleton := *data
+	skeleton.OriginalURI = sentinelIfSet(data.OriginalURI, sentinelOriginalURI)
+	skeleton.RewrittenURI = sentinelIfSet(data.RewrittenURI, sentinelRewrittenURI)
+	skeleton.ForwardedFor = sentinelIfSet(data.ForwardedFor, sentinelForwardedFor)
+	skeleton.RequestID = sentinelIfSet(data.RequestID, sentinelRequestID)
+	skeleton.TraceID = sentinelIfSet(data.TraceID, sentinelTraceID)
+	skeleton.SpanID = sentinelIfSet(data.SpanID, sentinelSpanID)
+	skeleton.TLSVersion = sentinelIfSet(data.TLSVersion, sentinelTLSVersion)
+	skeleton.PeerSubject = sentinelIfSet(data.PeerSubject, sentinelPeerSubject)
+	skeleton.PeerSAN = sentinelIfSet(data.PeerSAN, sentinelPeerSAN)
+	skeleton.StatusURL = sentinelIfSet(data.StatusURL, sentinelStatusURL)
+	skeleton.SupportMailto = sentinelIfSet(data.SupportMailto, sentinelSupportMail)
+	skeleton.SupportURL = sentinelIfSet(data.SupportURL, sentinelSupportURL)
+	skeleton.RequestHeaders = sentinelHeaders(data.RequestHeaders, "request_header_")
+	skeleton.ResponseHeaders = sentinelHeaders(data.ResponseHeaders, "response_header_")
+	if len(data.CorrelationIDs) > 0 {
+		skeleton.CorrelationIDs = make([][2]string, len(data.CorrelationIDs))
+		for i, id := range data.CorrelationIDs {
+			skeleton.CorrelationIDs[i] = [2]string{id[0], sentinelIfSet(id[1], valueSentinel("correlation_id_"+strconv.Itoa(i)))}
+		}
+	}
+	if data.RequestSize != 0 {
+		skeleton.RequestSize = sentinelRequestSize
+	}
+	if data.ResponseSize != 0 {
+		skeleton.ResponseSize = sentinelResponseSize
+	}
+	skeleton.skeleton = true
+	return h.RenderErrorPage(&skeleton)
+}
+
+// FillSkeleton writes skeleton into buf, which is reset first, substituting
+// the per-request values of data
+func FillSkeleton(buf *bytes.Buffer, skeleton []byte, data *TemplateData) {
+	if data.NowUnix == 0 {
+		data.NowUnix = time.Now().Unix()
+	}
+	if data.TimestampLayout == "" {
+		data.TimestampLayout = DefaultTimestampLayout
+	}
+	if data.Location == nil {
+		data.Location = time.UTC
+	}
+	pairs := []string{
+		sentinelNowUnix, strconv.FormatInt(data.NowUnix, 10),
+		sentinelTimestamp, data.timestamp(),
+		sentinelNonce, data.Nonce,
+		strconv.FormatInt(sentinelRequestSize, 10), strconv.FormatInt(data.RequestSize, 10),
+		strconv.FormatInt(sentinelResponseSize, 10), strconv.FormatInt(data.ResponseSize, 10),
+		sentinelRequestSizeBytes, formatBytes(data.RequestSize),
+		sentinelResponseSizeBytes, formatBytes(data.ResponseSize),
+	}
+	if bytes.Contains(skeleton, []byte(sentinelQRCode)) {
+		pairs = append(pairs, sentinelQRCode, data.qrCode())
+	}
+	for _, v := range [...]struct{ sentinel, value string }{
+		{sentinelOriginalURI, data.OriginalURI},
+		{sentinelRewrittenURI, data.RewrittenURI},
+		{sentinelForwardedFor, data.ForwardedFor},
+		{sentinelRequestID, data.RequestID},
+		{sentinelTraceID, data.TraceID},
+		{sentinelSpanID, data.SpanID},
+		{sentinelTLSVersion, data.TLSVersion},
+		{sentinelPeerSubject, data.PeerSubject},
+		{sentinelPeerSAN, data.PeerSAN},
+		{sentinelStatusURL, data.StatusURL},
+		{sentinelSupportMail, data.SupportMailto},
+		{sentinelSupportURL, data.SupportURL},
+	} {
+		pairs = append(pairs, valuePairs(v.sentinel, v.value)...)
+	}
+	for i, name := range sortedNames(data.RequestHeaders) {
+		pairs = append(pairs, valuePairs(valueSentinel("request_header_"+strconv.Itoa(i)), data.RequestHeaders[name])...)
+	}
+	for i, name := range sortedNames(data.ResponseHeaders) {
+		pairs = append(pairs, valuePairs(valueSentinel("response_header_"+strconv.Itoa(i)), data.ResponseHeaders[name])...)
+	}
+	for i, id := range data.CorrelationIDs {
+		pairs = append(pairs, valuePairs(valueSentinel("correlation_id_"+strconv.Itoa(i)), id[1])...)
+	}
+	r := strings.NewReplacer(pairs...)
+	buf.Reset()
+	buf.Grow(len(skeleton))
+	_, _ = r.WriteString(buf, string(skeleton))
+}
+
+func sentinelIfSet(value, sentinel string) string {
+	if value == "" {
+		return ""
+	}
+	return sentinel
+}
+
+// valuePairs returns the replacements of the sentinel of a request value and
+// of its raw and js variants
+func valuePairs(sentinel, value string) []string {
+	raw, _ := rawSentinel(sentinel)
+	js, _ := jsSentinel(sentinel)
+	return []string{
+		sentinel, html.EscapeString(value),
+		raw, value,
+		js, template.JSEscapeString(value),
+	}
+}
+
+// sentinelHeaders returns captured headers with their values replaced by
+// sentinels, numbered in the order of the header names
+func sentinelHeaders(headers map[string]string, prefix string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+	sentinels := make(map[string]string, len(headers))
+	for i, name := range sortedNames(headers) {
+		sentinels[name] = sentinelIfSet(headers[name], valueSentinel(prefix+strconv.Itoa(i)))
+	}
+	return sentinels
+}
+
+func sortedNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// correlationKey serializes the names of correlation IDs for SkeletonKey,
+// marking those that are set
+func correlationKey(ids [][2]string) string {
+	var b strings.Builder
+	b.WriteString("|")
+	for _, id := range ids {
+		b.WriteString(strconv.Quote(id[0]))
+		if id[1] != "" {
+			b.WriteString("=")
+		}
+	}
+	return b.String()
+}
+
+// headersKey serializes the names of captured headers for SkeletonKey,
+// marking those that are set
+func headersKey(headers map[string]string) string {
+	var b strings.Builder
+	b.WriteString("|")
+	for _, name := range sortedNames(headers) {
+		b.WriteString(strconv.Quote(name))
+		if headers[name] != "" {
+			b.WriteString("=")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,249 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errorpages
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// scriptOpen opens an inline script tag carrying the per-response CSP nonce
+// when one is set
+const scriptOpen = `<script{{ if nonce }} nonce="{{ nonce }}"{{ end }}>`
+
+// BeaconSnippet returns a snippet template that reports the status code,
+// host, request id and theme to endpoint. Method "image" requests a 1x1 image with
+// query parameters; anything else uses navigator.sendBeacon with a JSON body
+// and falls back to the image request where sendBeacon is unavailable.
+func BeaconSnippet(endpoint, method, theme string) string {
+	url := jsString(endpoint)
+
+	image := `var q="code="+p.code+"&host="+encodeURIComponent(p.host)+"&request_id="+encodeURIComponent(p.request_id)+"&theme="+encodeURIComponent(p.theme);` +
+		`new Image().src=u+(u.indexOf("?")<0?"?":"&")+q;`
+	send := image
+	if method != "image" {
+		send = `if(navigator.sendBeacon&&navigator.sendBeacon(u,JSON.stringify(p))){return}` + image
+	}
+
+	return scriptOpen + `(function(){try{` +
+		`var u="` + url + `";` +
+		`var p={code:{{ code }},host:"{{ host | js }}",request_id:"{{ request_id | js }}",theme:"` + jsString(theme) + `"};` +
+		send +
+		`}catch(e){}})();</script>`
+}
+
+// ErrorTrackingSnippet returns a snippet template that loads a Sentry-style
+// browser SDK from scriptURL, initializes it with the given DSN, environment
+// and release, and reports the rendered error page as a message tagged with
+// the status code and request id.
+func ErrorTrackingSnippet(scriptURL, dsn, environment, release string) string {
+	return `<script src="` + htmlAttr(scriptURL) + `" crossorigin="anonymous"{{ if nonce }} nonce="{{ nonce }}"{{ end }}></script>` +
+		scriptOpen + `(function(){if(!window.Sentry){return}try{` +
+		`Sentry.init({dsn:"` + jsString(dsn) + `",environment:"` + jsString(environment) + `",release:"` + jsString(release) + `"});` +
+		`Sentry.setTag("status_code","{{ code }}");` +
+		`Sentry.setTag("request_id","{{ request_id | js }}");` +
+		`Sentry.captureMessage("Error page shown: {{ code }} {{ message | js }}","warning");` +
+		`}catch(e){}})();</script>`
+}
+
+// LanguageSwitcherSnippet returns a snippet template that adds a language
+// picker to the page. It reads the translations of every language from the
+// l10nBundle function and swaps the data-l10n labels, the status message and
+// description, and the document title in place.
+func LanguageSwitcherSnippet() string {
+	return scriptOpen + `(function(){try{` +
+		`var b={{ l10nBundle }},h=document.documentElement,cur=b[h.lang]?h.lang:"en",labels=[],texts=[];` +
+		`if(!b[cur]){return}` +
+		`var els=document.querySelectorAll("[data-l10n]");` +
+		`for(var i=0;i<els.length;i++){var t=els[i].textContent.trim(),k=t,l=b[cur].labels;` +
+		`for(var x in l){if(l[x]===t){k=x;break}}labels.push([els[i],k])}` +
+		`var w=document.createTreeWalker(document.body||h,NodeFilter.SHOW_TEXT),n;` +
+		`while((n=w.nextNode())){var v=n.nodeValue.trim();` +
+		`if(v&&v===b[cur].message){texts.push([n,"message"])}else if(v&&v===b[cur].description){texts.push([n,"description"])}}` +
+		`function apply(lang){var d=b[lang],j;` +
+		`for(j=0;j<labels.length;j++){labels[j][0].textContent=d.labels[labels[j][1]]||labels[j][1]}` +
+		`for(j=0;j<texts.length;j++){texts[j][0].nodeValue=d[texts[j][1]]}` +
+		`document.title=document.title.replace(b[cur].message,d.message);` +
+		`h.lang=lang;h.dir=d.dir;cur=lang}` +
+		`var s=document.createElement("select");s.setAttribute("aria-label","Language");` +
+		`s.style.cssText="position:fixed;bottom:1em;inset-inline-end:1em;z-index:2147483647;font:inherit";` +
+		`for(var lang in b){var o=document.createElement("option");o.value=lang;o.textContent=b[lang].name;o.selected=lang===cur;s.appendChild(o)}` +
+		`s.onchange=function(){apply(s.value)};(document.body||h).appendChild(s);` +
+		`}catch(e){}})();</script>`
+}
+
+// IncidentBannerSnippet returns a snippet template that shows the incident
+// message, its expected resolution, a link to more details and the affected
+// components of the status page in a banner at the top of 5xx pages. Pages
+// without an incident message or affected components are left alone.
+func IncidentBannerSnippet() string {
+	return `{{ if and (or incident_message affected_components) (ge code 500) }}` +
+		`<style{{ if nonce }} nonce="{{ nonce }}"{{ end }}>` +
+		`.error-pages-incident{position:fixed;inset-block-start:0;inset-inline:0;z-index:2147483647;margin:0;padding:.75em 1em;` +
+		`background:#b3261e;color:#fff;font:15px/1.4 system-ui,-apple-system,"Segoe UI",sans-serif;text-align:center}` +
+		`.error-pages-incident a{color:inherit;font-weight:600}` +
+		`.error-pages-incident ul{display:flex;flex-wrap:wrap;justify-content:center;gap:0 1.5em;margin:0;padding:0;list-style:none}` +
+		`</style>` +
+		`<div class="error-pages-incident" role="alert">{{ incident_message }}` +
+		`{{ if incident_resolves_at }} <span data-l10n>{{ t "Expected resolution" }}</span>: {{ incident_resolves_at }}.{{ end }}` +
+		`{{ if incident_url }} <a href="{{ incident_url }}" data-l10n>{{ t "More details" }}</a>{{ end }}` +
+		`{{ with affected_components }}<ul>{{ range . }}<li>{{ .Name }}: <span data-l10n>{{ t .Status }}</span></li>{{ end }}</ul>{{ end }}` +
+		`</div>{{ end }}`
+}
+
+// QRCodeSnippet returns a snippet template that shows the QR code of the
+// support reference in the bottom corner of the page, so visitors can take
+// a picture of it instead of copying the request ID
+func QRCodeSnippet() string {
+	return `{{ with qr_code }}` +
+		`<style{{ if nonce }} nonce="{{ nonce }}"{{ end }}>` +
+		`.error-pages-qr{position:fixed;inset-block-end:1em;inset-inline-end:1em;z-index:2147483645;margin:0;width:7.5em;padding:.4em;` +
+		`border-radius:.5em;background:#fff;color:#333;box-shadow:0 2px 8px rgba(0,0,0,.25);font:11px/1.3 system-ui,-apple-system,"Segoe UI",sans-serif;text-align:center}` +
+		`.error-pages-qr svg{display:block;width:100%;height:auto}` +
+		`@media (max-width:480px){.error-pages-qr{display:none}}` +
+		`</style>` +
+		`<figure class="error-pages-qr">{{ . }}<figcaption data-l10n>{{ t "Scan for the support reference" }}</figcaption></figure>{{ end }}`
+}
+
+// CopyDetailsSnippet returns a snippet template that adds a button copying
+// the status code, host, path, request ID and time of the error to the
+// clipboard, for pasting into support tickets. Where the Clipboard API is
+// unavailable, e.g. on plain HTTP, it falls back to a hidden text area.
+func CopyDetailsSnippet() string {
+	return `<style{{ if nonce }} nonce="{{ nonce }}"{{ end }}>` +
+		`.error-pages-copy{position:fixed;inset-block-end:1em;inset-inline-start:1em;z-index:2147483645;margin:0;padding:.4em .9em;` +
+		`border:1px solid rgba(0,0,0,.2);border-radius:.4em;background:#fff;color:#333;box-shadow:0 2px 8px rgba(0,0,0,.2);` +
+		`font:13px/1.4 system-ui,-apple-system,"Segoe UI",sans-serif;cursor:pointer}` +
+		`.error-pages-copy .copied,.error-pages-copy.done .copy{display:none}.error-pages-copy.done .copied{display:inline}` +
+		`</style>` +
+		`<button type="button" class="error-pages-copy" id="error-pages-copy">` +
+		`<span class="copy" data-l10n>{{ t "Copy details" }}</span><span class="copied" data-l10n>{{ t "Copied" }}</span></button>` +
+		scriptOpen + `(function(){try{` +
+		`var b=document.getElementById("error-pages-copy"),l=["Error: {{ code }} {{ message | js }}"],` +
+		`f=[["Host","{{ host | js }}"],["Path","{{ original_uri | js }}"],["Request ID","{{ request_id | js }}"]];` +
+		`for(var i=0;i<f.length;i++){if(f[i][1]){l.push(f[i][0]+": "+f[i][1])}}` +
+		`l.push("Time: "+new Date({{ nowUnix }}*1000).toISOString());var text=l.join("\n");` +
+		`function done(){b.className+=" done";setTimeout(function(){b.className=b.className.replace(" done","")},2000)}` +
+		`function fallback(){var t=document.createElement("textarea");t.value=text;t.setAttribute("readonly","");` +
+		`t.style.cssText="position:fixed;opacity:0";document.body.appendChild(t);t.select();` +
+		`try{if(document.execCommand("copy")){done()}}catch(e){}document.body.removeChild(t)}` +
+		`b.onclick=function(){if(navigator.clipboard&&window.isSecureContext){navigator.clipboard.writeText(text).then(done,fallback)}else{fallback()}};` +
+		`}catch(e){}})();</script>`
+}
+
+// StatusLinkSnippet returns a snippet template that shows a button linking
+// to the status page in the top corner of pages with a status link, below
+// the incident banner when there is one. Themes placing status_url
+// themselves don't need it.
+func StatusLinkSnippet() string {
+	return `{{ with status_url }}` +
+		`<style{{ if nonce }} nonce="{{ nonce }}"{{ end }}>` +
+		`.error-pages-status{position:fixed;inset-block-start:1em;inset-inline-end:1em;z-index:2147483645;padding:.5em 1em;` +
+		`border-radius:.4em;background:#1f6feb;color:#fff;box-shadow:0 2px 8px rgba(0,0,0,.25);` +
+		`font:600 14px/1.4 system-ui,-apple-system,"Segoe UI",sans-serif;text-decoration:none}` +
+		`{{ if and (or incident_message affected_components) (ge code 500) }}.error-pages-status{inset-block-start:4em}{{ end }}` +
+		`</style>` +
+		`<a class="error-pages-status" href="{{ . }}" data-l10n>{{ t "View service status" }}</a>{{ end }}`
+}
+
+// CustomScriptSnippet returns a snippet template adding the custom script
+// to the end of the page. Themes placing custom_script themselves, e.g. in
+// their head, don't need it.
+func CustomScriptSnippet() string {
+	return `{{ custom_script }}`
+}
+
+// customScript implements the custom_script function: the inline part of
+// the custom script followed by the script of its URL, both carrying the
+// CSP nonce
+func (h *Handler) customScript() string {
+	attr := ""
+	if nonce := h.nonce(); nonce != "" {
+		attr = ` nonce="` + nonce + `"`
+	}
+	var b strings.Builder
+	if h.data.CustomScriptInline != "" {
+		b.WriteString(`<script` + attr + `>` + h.data.CustomScriptInline + `</script>`)
+	}
+	if h.data.CustomScriptURL != "" {
+		b.WriteString(`<script src="` + html.EscapeString(h.data.CustomScriptURL) + `" defer` + attr + `></script>`)
+	}
+	return b.String()
+}
+
+// SupportSnippet returns a snippet template that shows the contact of the
+// support team in the top corner of the page, below the incident banner
+// when there is one. Themes placing the support values themselves don't
+// need it.
+func SupportSnippet() string {
+	return `{{ if or support_email support_url support_phone }}` +
+		`<style{{ if nonce }} nonce="{{ nonce }}"{{ end }}>` +
+		`.error-pages-support{position:fixed;inset-block-start:1em;inset-inline-start:1em;z-index:2147483645;max-width:50vw;margin:0;padding:.6em .9em;` +
+		`border-radius:.5em;background:#fff;color:#333;box-shadow:0 2px 8px rgba(0,0,0,.25);font:13px/1.5 system-ui,-apple-system,"Segoe UI",sans-serif}` +
+		`.error-pages-support a{display:block;color:#0b57d0}` +
+		`{{ if and (or incident_message affected_components) (ge code 500) }}.error-pages-support{inset-block-start:4em}{{ end }}` +
+		`</style>` +
+		`<aside class="error-pages-support"><strong data-l10n>{{ t "Need help?" }}</strong>` +
+		`{{ if support_email }}<a href="{{ or support_mailto (print "mailto:" support_email) }}" data-l10n>{{ t "Email support" }}</a>{{ end }}` +
+		`{{ with support_url }}<a href="{{ . }}" data-l10n>{{ t "Open a support ticket" }}</a>{{ end }}` +
+		`{{ if support_phone }}<a href="{{ support_tel }}"><span data-l10n>{{ t "Call support" }}</span>: {{ support_phone }}</a>{{ end }}` +
+		`</aside>{{ end }}`
+}
+
+// RetryCountdownSnippet returns a snippet template that reloads pages of
+// transient errors after a visible countdown, at most maxAttempts times in a
+// row, and then shows that the service is still down. Attempts are counted
+// per URL in sessionStorage and start over when the page wasn't reloaded for
+// three retry intervals.
+func RetryCountdownSnippet(maxAttempts int) string {
+	return `{{ if retryable }}` +
+		`<style{{ if nonce }} nonce="{{ nonce }}"{{ end }}>` +
+		`.error-pages-retry{position:fixed;inset-block-end:1em;left:50%;transform:translateX(-50%);z-index:2147483646;margin:0;` +
+		`padding:.5em 1em;border-radius:2em;background:rgba(0,0,0,.75);color:#fff;font:14px/1.4 system-ui,-apple-system,"Segoe UI",sans-serif}` +
+		`.error-pages-retry a{color:inherit;font-weight:600}` +
+		`.error-pages-retry .stopped,.error-pages-retry.done .running{display:none}.error-pages-retry.done .stopped{display:inline}` +
+		`</style>` +
+		`<div class="error-pages-retry" id="error-pages-retry" role="status">` +
+		`<span class="running"><span data-l10n>{{ t "Retrying in" }}</span> <span class="countdown">{{ retry_interval }}</span> s</span>` +
+		`<span class="stopped"><span data-l10n>{{ t "Still down. Please try again later." }}</span> <a href="" data-l10n>{{ t "Try again" }}</a></span>` +
+		`</div>` +
+		scriptOpen + `(function(){try{` +
+		`var max=` + strconv.Itoa(maxAttempts) + `,iv={{ retry_interval }},s=window.sessionStorage,k="error-pages-retry:"+location.pathname+location.search,st={n:0,t:0};` +
+		`try{st=JSON.parse(s.getItem(k))||st}catch(e){}` +
+		`if(Date.now()-st.t>iv*3000){st.n=0}` +
+		`var el=document.getElementById("error-pages-retry"),c=el.querySelector(".countdown");` +
+		`if(st.n>=max){el.className+=" done";s.removeItem(k);return}` +
+		`var left=iv,timer=setInterval(function(){c.textContent=--left;if(left>0){return}clearInterval(timer);` +
+		`st.n++;st.t=Date.now();s.setItem(k,JSON.stringify(st));location.reload()},1000);` +
+		`}catch(e){}})();</script>{{ end }}`
+}
+
+// jsString escapes s for use inside a double-quoted JavaScript string that is
+// itself part of a snippet template, so braces can't form template actions.
+func jsString(s string) string {
+	s = template.JSEscapeString(s)
+	s = strings.ReplaceAll(s, "{", `\u007B`)
+	return strings.ReplaceAll(s, "}", `\u007D`)
+}
+
+// htmlAttr escapes s for use inside a double-quoted HTML attribute that is
+// itself part of a snippet template.
+func htmlAttr(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, "{", "&#123;")
+	return strings.ReplaceAll(s, "}", "&#125;")
+}
@@ -0,0 +1,83 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errorpages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// styleElement matches the inline style elements of a template
+var styleElement = regexp.MustCompile(`(?is)<style\b[^>]*>(.*?)</style>`)
+
+// UseStylesheets moves the inline styles of the page to stylesheets served
+// from baseURL, so rendered pages only carry their markup. Styles may depend
+// on the status code and the configuration, so they are rendered for every
+// known status code with data set up by prepare, with request details shown.
+// The returned stylesheets are named by the hash of their content and must
+// be published at baseURL. Pages of other status codes keep inline styles.
+func (h *Handler) UseStylesheets(baseURL string, prepare func(data *TemplateData)) (map[string][]byte, error) {
+	blocks := styleElement.FindAllStringSubmatch(h.templateText, -1)
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+	var css strings.Builder
+	for _, block := range blocks {
+		css.WriteString(block[1])
+		css.WriteString("\n")
+	}
+	tmpl, err := template.New("stylesheet").Funcs(h.funcs()).Parse(css.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse styles: %w", err)
+	}
+
+	files := make(map[string][]byte)
+	stylesheets := make(map[int]string)
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	for _, code := range KnownStatusCodes() {
+		data := &TemplateData{Code: code, ShowDetails: true}
+		prepare(data)
+		var buf bytes.Buffer
+		h.data = data
+		err := tmpl.Execute(&buf, data)
+		h.data = nil
+		if err != nil {
+			return nil, fmt.Errorf("failed to render styles of status %d: %w", code, err)
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		name := hex.EncodeToString(sum[:8]) + ".css"
+		files[name] = buf.Bytes()
+		stylesheets[code] = baseURL + "/" + name
+	}
+
+	first := true
+	h.templateText = styleElement.ReplaceAllStringFunc(h.templateText, func(style string) string {
+		if first {
+			first = false
+			return `{{ if stylesheet }}<link rel="stylesheet" href="{{ stylesheet }}" />{{ else }}` + style + `{{ end }}`
+		}
+		return `{{ if not stylesheet }}` + style + `{{ end }}`
+	})
+	h.stylesheets = stylesheets
+	if err := h.compile(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -1,4 +1,4 @@
-module envoy-wasm-error-pages
+module github.com/ishioni/envoy-wasm-error-pages
 
 go 1.25.0
 
@@ -6,3 +6,5 @@ require (
 	github.com/proxy-wasm/proxy-wasm-go-sdk v0.0.0-20260105142703-44c7d5847745
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/tetratelabs/wazero v1.7.2 // indirect
@@ -15,44 +15,1086 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/logging"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/statuspage"
+
+	"gopkg.in/yaml.v3"
+)
+
+// External asset modes
+const (
+	// ExternalAssetsAllow leaves external references in templates alone
+	ExternalAssetsAllow = "allow"
+	// ExternalAssetsReject fails plugin start when the template has any
+	ExternalAssetsReject = "reject"
+	// ExternalAssetsStrip removes them from the template
+	ExternalAssetsStrip = "strip"
+)
+
+// Dark mode settings
+const (
+	// DarkModeAuto follows the visitor's system preference
+	DarkModeAuto = "auto"
+	// DarkModeAlways renders the dark variant of themes
+	DarkModeAlways = "always"
+	// DarkModeNever renders the light variant of themes
+	DarkModeNever = "never"
+)
+
+// Response body handling modes
+const (
+	// BodyModeBuffer waits for the whole upstream body before replacing it
+	BodyModeBuffer = "buffer"
+	// BodyModeDiscard replaces the first upstream chunk and drops the rest
+	BodyModeDiscard = "discard"
+)
+
+// Traffic directions handled by the plugin
+const (
+	// TrafficAny handles the traffic of every listener
+	TrafficAny = "any"
+	// TrafficInbound only handles the traffic of inbound listeners, e.g. of
+	// the sidecar in front of a workload
+	TrafficInbound = "inbound"
+	// TrafficOutbound only handles the traffic of outbound listeners
+	TrafficOutbound = "outbound"
+)
+
+// Theme selection modes, used as the theme name
+const (
+	// ThemeRandom picks a theme of ThemePool for every error page
+	ThemeRandom = "random"
+	// ThemeRotate switches to the next theme of ThemePool every day
+	ThemeRotate = "rotate"
+	// ThemeWeighted assigns each client a theme of ThemeWeights, in
+	// proportion to its weight, and keeps it across requests
+	ThemeWeighted = "weighted"
 )
 
 // Config represents the plugin configuration
 type Config struct {
-	Theme       string
-	ShowDetails bool
+	Theme       string `yaml:"theme"`
+	ShowDetails bool   `yaml:"show_details"`
+	BodyMode    string `yaml:"body_mode"`
+	LogFormat   string `yaml:"log_format"`
+	// LogLevel is the minimum level of per-request log entries, which the
+	// control endpoint can change at runtime
+	LogLevel string `yaml:"log_level"`
+	// ThemePool lists the themes picked by theme: random and theme: rotate
+	ThemePool []string `yaml:"theme_pool"`
+	// Theme4xx and Theme5xx replace Theme for client and server errors
+	Theme4xx string `yaml:"theme_4xx"`
+	Theme5xx string `yaml:"theme_5xx"`
+	// ThemeWeights maps the variants of theme: weighted to their relative
+	// weights
+	ThemeWeights map[string]int `yaml:"theme_weights"`
+	// ThemeCookie names the cookie that keeps a client on its variant,
+	// required by theme: weighted
+	ThemeCookie string `yaml:"theme_cookie"`
+	// LogSampleRate logs 1 of every N intercepted responses
+	LogSampleRate int `yaml:"log_sample_rate"`
+	// LogSummaryInterval is the period, in seconds, between interception
+	// count summaries; 0 disables them
+	LogSummaryInterval int `yaml:"log_summary_interval"`
+	// Timezone of displayed timestamps: "UTC", a fixed offset such as
+	// "+02:00", or an IANA name such as "Europe/Berlin"
+	Timezone string `yaml:"timezone"`
+	// StripProxyHeaders removes server, via and x-envoy-* headers from
+	// intercepted responses
+	StripProxyHeaders bool `yaml:"strip_proxy_headers"`
+	// NoIndex keeps crawlers from indexing error pages, with a robots meta
+	// tag in themes lacking one and an X-Robots-Tag header
+	NoIndex bool `yaml:"noindex"`
+	// ExternalAssets controls external src/href references in the template
+	// so pages render offline: "allow", "reject" or "strip"
+	ExternalAssets string `yaml:"external_assets"`
+	// DarkMode selects the color scheme of themes: "auto", "always" or
+	// "never"
+	DarkMode string `yaml:"dark_mode"`
+	// ThemeVariables override the defaults of the variables the theme
+	// declares in its manifest, such as the corporate theme's branding
+	ThemeVariables map[string]string `yaml:"theme_variables"`
+	// Routes override settings for the requests of an Envoy route, keyed by
+	// the route name, or by the virtual host name to cover all its routes
+	Routes map[string]Route `yaml:"routes"`
+	// TrafficDirection limits the plugin to the listeners of a traffic
+	// direction: "any", "inbound" or "outbound"
+	TrafficDirection string `yaml:"traffic_direction"`
+	// RouteMetadata reads route rules from the metadata of the matched route
+	RouteMetadata RouteMetadata `yaml:"route_metadata"`
+	// Node shows which Envoy node served the page in the request details
+	Node Node `yaml:"node"`
+	// CaptureHeaders exposes the named request and response headers to
+	// templates
+	CaptureHeaders CaptureHeaders `yaml:"capture_headers"`
+	// CorrelationHeaders name request headers carrying correlation IDs, e.g.
+	// x-correlation-id, which are shown in the request details, logged and
+	// echoed on error responses
+	CorrelationHeaders []string `yaml:"correlation_headers"`
+	// StatusCodes add or replace the message and description of status
+	// codes, keyed by code, or by "4xx"/"5xx" for codes without a built-in
+	// message
+	StatusCodes map[string]StatusText `yaml:"status_codes"`
+	// RetryAfter is the Retry-After header, in seconds, added to error
+	// responses without one, keyed like StatusCodes
+	RetryAfter map[string]int `yaml:"retry_after"`
+	// Retry reloads the pages of transient errors (408, 425, 429, 500 and
+	// 502-504)
+	Retry Retry `yaml:"retry"`
+	// QRCode shows a QR code of the support reference on every page
+	QRCode QRCode `yaml:"qr_code"`
+	// CopyDetails adds a button copying the status code, host, path,
+	// request ID and time of the error to the clipboard
+	CopyDetails CopyDetails `yaml:"copy_details"`
+	// StatusLink adds a button linking to the status or incident page
+	StatusLink StatusLink `yaml:"status_link"`
+	// Support shows how to contact support, with links prefilled with the
+	// details of the error
+	Support Support `yaml:"support"`
+	// CustomScript adds the team's own script, such as a chat widget or a
+	// feedback form, to every page
+	CustomScript CustomScript `yaml:"custom_script"`
+
+	Beacon        Beacon        `yaml:"beacon"`
+	ErrorTracking ErrorTracking `yaml:"error_tracking"`
+	Debug         Debug         `yaml:"debug"`
+	Status        Status        `yaml:"status"`
+	Control       Control       `yaml:"control"`
+	ConfigDump    ConfigDump    `yaml:"config_dump"`
+	Preview       Preview       `yaml:"preview"`
+	RecentErrors  RecentErrors  `yaml:"recent_errors"`
+	RenderCache   RenderCache   `yaml:"render_cache"`
+	Localization  Localization  `yaml:"localization"`
+	CSP           CSP           `yaml:"csp"`
+	Privacy       Privacy       `yaml:"privacy"`
+	CDN           CDN           `yaml:"cdn"`
+	Metadata      Metadata      `yaml:"metadata"`
+
+	IncidentBanner  IncidentBanner  `yaml:"incident_banner"`
+	StatusProvider  StatusProvider  `yaml:"status_provider"`
+	SecurityHeaders SecurityHeaders `yaml:"security_headers"`
+}
+
+// Beacon configures the analytics beacon injected into rendered pages
+type Beacon struct {
+	Enabled  bool   `yaml:"enabled"`
+	Endpoint string `yaml:"endpoint"`
+	// Method is either "beacon" (navigator.sendBeacon) or "image"
+	Method string `yaml:"method"`
+}
+
+// CDN moves the inline styles of themes, including the images they embed,
+// to stylesheets served from BaseURL, so pages only carry their markup
+type CDN struct {
+	Enabled bool   `yaml:"enabled"`
+	BaseURL string `yaml:"base_url"`
+}
+
+// Metadata brands the page title, icon and link previews of every theme
+type Metadata struct {
+	// TitlePrefix is prepended to the page title and the link preview title
+	TitlePrefix string `yaml:"title_prefix"`
+	// Favicon is the page icon, preferably a data URI
+	Favicon string `yaml:"favicon"`
+	// OGTitle replaces the link preview title, "{title_prefix}{code}: {message}"
+	OGTitle string `yaml:"og_title"`
+	// OGImage is the absolute URL of the link preview image
+	OGImage string `yaml:"og_image"`
+}
+
+// Route overrides settings for the requests of an Envoy route or virtual
+// host
+type Route struct {
+	// Skip passes the error responses of the route through unchanged
+	Skip bool `yaml:"skip"`
+	// Theme replaces the theme, the pool and the class themes
+	Theme string `yaml:"theme"`
+	// ShowDetails replaces show_details when set
+	ShowDetails *bool `yaml:"show_details"`
+}
+
+// RouteMetadata reads the skip, theme and show_details keys of a route rule
+// from the filter metadata of the matched route, so routes can be configured
+// in the route table. They override the fields of matching Routes.
+type RouteMetadata struct {
+	Enabled bool `yaml:"enabled"`
+	// Namespace is the filter_metadata key holding the rule
+	Namespace string `yaml:"namespace"`
+	// Themes are the themes metadata may select besides the configured ones.
+	// They are loaded at start like those; other themes are ignored.
+	Themes []string `yaml:"themes"`
+}
+
+// Node exposes the id, cluster and selected metadata of the Envoy node to
+// pages showing request details
+type Node struct {
+	Enabled bool `yaml:"enabled"`
+	// MetadataKeys name the node metadata fields exposed, e.g. region
+	MetadataKeys []string `yaml:"metadata_keys"`
+}
+
+// CaptureHeaders names the headers exposed to templates as request_header
+// and response_header. Names are case-insensitive.
+type CaptureHeaders struct {
+	Request  []string `yaml:"request"`
+	Response []string `yaml:"response"`
+}
+
+// StatusText is the message and description of a status code. Empty fields
+// keep the built-in text.
+type StatusText struct {
+	Message     string `yaml:"message"`
+	Description string `yaml:"description"`
+}
+
+// Retry configures the automatic reloading of transient error pages
+type Retry struct {
+	// Interval is the delay before a reload in seconds; 0 disables reloading
+	Interval int `yaml:"interval"`
+	// Countdown shows the seconds left before the reload and stops after
+	// MaxAttempts reloads in a row, telling the visitor to try again later
+	Countdown   bool `yaml:"countdown"`
+	MaxAttempts int  `yaml:"max_attempts"`
+}
+
+// QRCode encodes the request ID, or a support URL carrying it, in a QR code
+// that visitors can photograph
+type QRCode struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is encoded instead of the bare request ID, with "{request_id}"
+	// replaced by the displayed request ID
+	URL string `yaml:"url"`
+}
+
+// Text returns the text encoded for a request, or an empty string when the
+// code is disabled or needs a request ID the request doesn't have
+func (q QRCode) Text(requestID string) string {
+	if !q.Enabled {
+		return ""
+	}
+	if q.URL == "" {
+		return requestID
+	}
+	if !strings.Contains(q.URL, "{request_id}") {
+		return q.URL
+	}
+	if requestID == "" {
+		return ""
+	}
+	return strings.ReplaceAll(q.URL, "{request_id}", url.QueryEscape(requestID))
+}
+
+// CopyDetails configures the copy-to-clipboard button of the error details
+type CopyDetails struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// StatusLink links error pages to a status or incident page
+type StatusLink struct {
+	// URL is the link target, with "{code}", "{request_id}" and "{host}"
+	// replaced by the values of the request
+	URL string `yaml:"url"`
+	// Codes are the status codes linking to it, keyed like status_codes
+	Codes []string `yaml:"codes"`
+}
+
+// URLFor returns the link of a request, or an empty string when the status
+// code doesn't link to the status page
+func (s StatusLink) URLFor(code int, requestID, host string) string {
+	if s.URL == "" {
+		return ""
+	}
+	class := "4xx"
+	if code >= 500 {
+		class = "5xx"
+	}
+	if !slices.Contains(s.Codes, strconv.Itoa(code)) && !slices.Contains(s.Codes, class) {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{code}", strconv.Itoa(code),
+		"{request_id}", url.QueryEscape(requestID),
+		"{host}", url.QueryEscape(host),
+	).Replace(s.URL)
+}
+
+// Support is the contact of the support team. The email and ticket links
+// are prefilled with the status code, request ID and time of the error.
+type Support struct {
+	Email string `yaml:"email"`
+	// URL is the ticket form, with "{code}", "{request_id}" and
+	// "{timestamp}" replaced by the values of the request
+	URL   string `yaml:"url"`
+	Phone string `yaml:"phone"`
+}
+
+// Enabled reports whether any contact is configured
+func (s Support) Enabled() bool {
+	return s.Email != "" || s.URL != "" || s.Phone != ""
+}
+
+// MailtoURL returns the mailto link of the support email with the subject
+// and body prefilled, or an empty string without an email
+func (s Support) MailtoURL(code int, message, requestID string, at time.Time) string {
+	if s.Email == "" {
+		return ""
+	}
+	subject := "Error " + strconv.Itoa(code) + " " + message
+	body := "Status: " + strconv.Itoa(code) + " " + message + "\n"
+	if requestID != "" {
+		subject += " (" + requestID + ")"
+		body += "Request ID: " + requestID + "\n"
+	}
+	body += "Time: " + at.UTC().Format(time.RFC3339) + "\n\n"
+	return "mailto:" + s.Email + "?subject=" + mailtoEscape(subject) + "&body=" + mailtoEscape(body)
+}
+
+// TicketURL returns the ticket form link of a request, or an empty string
+// without a ticket form
+func (s Support) TicketURL(code int, requestID string, at time.Time) string {
+	if s.URL == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{code}", strconv.Itoa(code),
+		"{request_id}", url.QueryEscape(requestID),
+		"{timestamp}", url.QueryEscape(at.UTC().Format(time.RFC3339)),
+	).Replace(s.URL)
+}
+
+// TelURL returns the tel link of the support phone number, or an empty
+// string without one
+func (s Support) TelURL() string {
+	if s.Phone == "" {
+		return ""
+	}
+	digits := strings.Map(func(r rune) rune {
+		if r == '+' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s.Phone)
+	return "tel:" + digits
+}
+
+// mailtoEscape escapes a header value of a mailto link. Mail clients don't
+// decode "+" as a space.
+func mailtoEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// CustomScript is a script added to every page, before </body> unless the
+// theme places it with the custom_script placeholder. Both parts carry the
+// CSP nonce.
+type CustomScript struct {
+	// URL of a script loaded with defer
+	URL string `yaml:"url"`
+	// Inline is JavaScript run before the script of URL, e.g. to configure
+	// it
+	Inline string `yaml:"inline"`
+}
+
+// Enabled reports whether a script is configured
+func (s CustomScript) Enabled() bool {
+	return s.URL != "" || s.Inline != ""
+}
+
+// IncidentBanner periodically fetches a JSON document describing an ongoing
+// incident from an Envoy cluster and shows it on 5xx pages
+type IncidentBanner struct {
+	Enabled bool `yaml:"enabled"`
+	// Cluster is the Envoy cluster serving the document
+	Cluster string `yaml:"cluster"`
+	// Authority is the host of the request, the cluster name when empty
+	Authority string `yaml:"authority"`
+	Path      string `yaml:"path"`
+	// Interval is the period between fetches in seconds
+	Interval int `yaml:"interval"`
+	// Timeout of a fetch in seconds
+	Timeout int `yaml:"timeout"`
+}
+
+// StatusProvider periodically reads the component statuses of a status page
+// API and shows the affected components on 5xx pages
+type StatusProvider struct {
+	Enabled bool `yaml:"enabled"`
+	// Type is the API: "statuspage" (statuspage.io), "cachet" or "json"
+	Type string `yaml:"type"`
+	// Cluster is the Envoy cluster serving the API
+	Cluster string `yaml:"cluster"`
+	// Authority is the host of the request, the cluster name when empty
+	Authority string `yaml:"authority"`
+	// Path lists the components, the API's own path when empty
+	Path string `yaml:"path"`
+	// Components limit the components shown, by name; empty shows all
+	Components []string `yaml:"components"`
+	// Interval is the period between fetches in seconds
+	Interval int `yaml:"interval"`
+	// Timeout of a fetch in seconds
+	Timeout int `yaml:"timeout"`
+}
+
+// ErrorTracking configures the Sentry-style error reporting snippet
+// injected into rendered pages. The release is always the plugin version.
+type ErrorTracking struct {
+	Enabled     bool   `yaml:"enabled"`
+	DSN         string `yaml:"dsn"`
+	Environment string `yaml:"environment"`
+	// ScriptURL is the browser SDK bundle loaded before initialization
+	ScriptURL string `yaml:"script_url"`
+}
+
+// Debug configures the shared secret of every debug endpoint the plugin
+// serves
+type Debug struct {
+	Token       string `yaml:"token"`
+	TokenHeader string `yaml:"token_header"`
+}
+
+// Status configures the JSON status endpoint
+type Status struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// Control configures the control endpoint, which switches maintenance mode,
+// sets a banner message and changes the log level of every worker at runtime
+type Control struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// ConfigDump configures the effective configuration endpoint, which serves
+// the configuration the plugin runs with as JSON
+type ConfigDump struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+// Preview configures the preview endpoint, which renders the page of the
+// status code in the last path segment, e.g. /__error_pages/preview/503
+type Preview struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is the prefix of preview requests
+	Path string `yaml:"path"`
+}
+
+// RecentErrors configures the recent errors endpoint, which serves the last
+// intercepted errors of every worker as JSON
+type RecentErrors struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+	// Size is the number of errors kept
+	Size int `yaml:"size"`
+}
+
+// RenderCache configures the shared-data cache of rendered detail pages
+type RenderCache struct {
+	Enabled bool `yaml:"enabled"`
+	// TTL is the lifetime of a cached page in seconds
+	TTL int `yaml:"ttl"`
+	// MaxEntries is the number of shared data slots pages are stored in
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// Localization configures translated error pages
+type Localization struct {
+	// Enabled negotiates the page language from Accept-Language
+	Enabled bool `yaml:"enabled"`
+	// DefaultLanguage is used when negotiation fails, or for every page when
+	// negotiation is disabled
+	DefaultLanguage string `yaml:"default_language"`
+	// ClientScript injects the templates' l10n script, which translates the
+	// page labels to the browser's preferred language
+	ClientScript bool `yaml:"client_script"`
+	// Switcher adds a language picker that swaps the page strings
+	// client-side
+	Switcher bool `yaml:"switcher"`
+	// LocaleHeader names the request or upstream response header, e.g. set
+	// by an auth filter, whose language overrides Accept-Language; empty
+	// disables it
+	LocaleHeader string `yaml:"locale_header"`
+	// Overrides add or replace translations per language, so wording fixes
+	// don't need a new plugin release
+	Overrides map[string]TranslationOverride `yaml:"overrides"`
+}
+
+// TranslationOverride holds the operator-supplied strings of one language.
+// Messages and descriptions are keyed by status code or "4xx"/"5xx", labels
+// by their English text.
+type TranslationOverride struct {
+	Messages     map[string]string `yaml:"messages"`
+	Descriptions map[string]string `yaml:"descriptions"`
+	Labels       map[string]string `yaml:"labels"`
+	// Name is the language's own name, shown in the language switcher
+	Name string `yaml:"name"`
+	// TimestampLayout is the Go time layout of displayed timestamps
+	TimestampLayout string `yaml:"timestamp_layout"`
+}
+
+// CSP configures the Content-Security-Policy header of rendered pages
+type CSP struct {
+	Enabled bool `yaml:"enabled"`
+	// Policy replaces the generated policy; "{nonce}" is replaced with the
+	// per-response nonce
+	Policy string `yaml:"policy"`
+}
+
+// Privacy configures masking of request details shown on rendered pages.
+// Logs keep the full values.
+type Privacy struct {
+	MaskClientIP       bool `yaml:"mask_client_ip"`
+	HideForwardedChain bool `yaml:"hide_forwarded_chain"`
+	// RequestIDLength truncates displayed request IDs; 0 shows them whole
+	RequestIDLength int `yaml:"request_id_length"`
+}
+
+// SecurityHeaders configures the standard security headers set on
+// intercepted responses
+type SecurityHeaders struct {
+	Enabled bool `yaml:"enabled"`
+	// Overrides replace the value of a default header, or add a header;
+	// an empty value drops the header
+	Overrides map[string]string `yaml:"overrides"`
+}
+
+// DefaultSecurityHeaders are set when security_headers is enabled
+var DefaultSecurityHeaders = map[string]string{
+	"x-content-type-options": "nosniff",
+	"referrer-policy":        "no-referrer",
+	"x-frame-options":        "DENY",
+}
+
+// Headers returns the security headers to set, sorted by name
+func (s SecurityHeaders) Headers() [][2]string {
+	merged := make(map[string]string, len(DefaultSecurityHeaders)+len(s.Overrides))
+	for name, value := range DefaultSecurityHeaders {
+		merged[name] = value
+	}
+	for name, value := range s.Overrides {
+		merged[strings.ToLower(name)] = value
+	}
+	headers := make([][2]string, 0, len(merged))
+	for name, value := range merged {
+		if value != "" {
+			headers = append(headers, [2]string{name, value})
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i][0] < headers[j][0] })
+	return headers
 }
 
 // Parse parses the configuration from YAML content
 func Parse(yamlContent []byte) (*Config, error) {
 	cfg := &Config{
-		Theme:       "cats", // Default to cats theme
-		ShowDetails: true,   // Default to true
+		Theme:       "cats",         // Default to cats theme
+		ShowDetails: true,           // Default to true
+		BodyMode:    BodyModeBuffer, // Default to buffering the upstream body
+		LogFormat:   "text",         // Default to free-form text logs
+		LogLevel:    "debug",        // Default to leaving log filtering to the host
+
+		LogSampleRate:      1,  // Default to logging every interception
+		LogSummaryInterval: 60, // Default to one summary per minute
+		Timezone:           "UTC",
+		ExternalAssets:     ExternalAssetsAllow,
+		DarkMode:           DarkModeAuto,
+		TrafficDirection:   TrafficAny,
+		ThemeCookie:        "error_pages_theme",
+
+		Beacon: Beacon{
+			Method: "beacon",
+		},
+		ErrorTracking: ErrorTracking{
+			Environment: "production",
+		},
+		RenderCache: RenderCache{
+			TTL:        5,
+			MaxEntries: 256,
+		},
+		Localization: Localization{
+			DefaultLanguage: "en",
+			LocaleHeader:    "x-error-locale",
+		},
+		Debug: Debug{
+			TokenHeader: "x-error-pages-token",
+		},
+		Status: Status{
+			Path: "/.well-known/error-pages/status",
+		},
+		Control: Control{
+			Path: "/.well-known/error-pages/control",
+		},
+		ConfigDump: ConfigDump{
+			Path: "/.well-known/error-pages/config",
+		},
+		Preview: Preview{
+			Path: "/__error_pages/preview",
+		},
+		RecentErrors: RecentErrors{
+			Path: "/.well-known/error-pages/recent",
+			Size: 50,
+		},
+		IncidentBanner: IncidentBanner{
+			Path:     "/incident.json",
+			Interval: 30,
+			Timeout:  5,
+		},
+		StatusProvider: StatusProvider{
+			Type:     statuspage.TypeStatuspage,
+			Interval: 60,
+			Timeout:  5,
+		},
+		RouteMetadata: RouteMetadata{
+			Namespace: "envoy.filters.http.wasm.error_pages",
+		},
+		Retry: Retry{
+			Interval:    30,
+			MaxAttempts: 5,
+		},
+		StatusLink: StatusLink{
+			Codes: []string{"5xx"},
+		},
 	}
 
-	// Simple YAML parser for show_details field
-	lines := strings.Split(string(yamlContent), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	if err := yaml.Unmarshal(yamlContent, cfg); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
 
-		// Skip comments and empty lines
-		if strings.HasPrefix(line, "#") || line == "" {
-			continue
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Themes returns every theme the configuration may render
+func (c *Config) Themes() []string {
+	themes := slices.Clone(c.BaseThemes())
+	extra := []string{c.Theme4xx, c.Theme5xx}
+	for _, name := range c.RouteNames() {
+		extra = append(extra, c.Routes[name].Theme)
+	}
+	if c.RouteMetadata.Enabled {
+		extra = append(extra, c.RouteMetadata.Themes...)
+	}
+	for _, theme := range extra {
+		if theme != "" && !slices.Contains(themes, theme) {
+			themes = append(themes, theme)
 		}
+	}
+	return themes
+}
 
-		// Parse theme
-		if strings.HasPrefix(line, "theme:") {
-			value := strings.TrimSpace(strings.TrimPrefix(line, "theme:"))
-			cfg.Theme = value
+// RouteNames returns the keys of Routes, sorted
+func (c *Config) RouteNames() []string {
+	names := make([]string, 0, len(c.Routes))
+	for name := range c.Routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BaseThemes returns the themes of status codes without a class-specific
+// theme: the variants, sorted, the theme pool, or the configured theme
+func (c *Config) BaseThemes() []string {
+	if c.Theme == ThemeWeighted {
+		names := make([]string, 0, len(c.ThemeWeights))
+		for name := range c.ThemeWeights {
+			names = append(names, name)
 		}
+		sort.Strings(names)
+		return names
+	}
+	if c.UsesThemePool() {
+		return c.ThemePool
+	}
+	return []string{c.Theme}
+}
+
+// UsesThemePool reports whether the theme is picked from ThemePool or
+// ThemeWeights rather than fixed
+func (c *Config) UsesThemePool() bool {
+	return c.Theme == ThemeRandom || c.Theme == ThemeRotate || c.Theme == ThemeWeighted
+}
+
+// themeMode reports whether theme names a selection mode
+func themeMode(theme string) bool {
+	return theme == ThemeRandom || theme == ThemeRotate || theme == ThemeWeighted
+}
+
+// Location resolves the configured timezone. IANA names need the time zone
+// database, which the wasm runtime lacks unless the plugin is built with the
+// timetzdata tag.
+func (c *Config) Location() (*time.Location, error) {
+	if c.Timezone == "" || c.Timezone == "UTC" {
+		return time.UTC, nil
+	}
+	if t, err := time.Parse("-07:00", c.Timezone); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone("UTC"+c.Timezone, offset), nil
+	}
+	return time.LoadLocation(c.Timezone)
+}
+
+// DebugEndpoints reports whether any token-gated debug endpoint is enabled
+func (c *Config) DebugEndpoints() bool {
+	return c.Status.Enabled || c.Control.Enabled || c.ConfigDump.Enabled || c.Preview.Enabled || c.RecentErrors.Enabled
+}
 
-		// Parse show_details
-		if strings.HasPrefix(line, "show_details:") {
-			value := strings.TrimSpace(strings.TrimPrefix(line, "show_details:"))
-			cfg.ShowDetails = value == "true"
+// StaticPages reports whether rendered pages depend on nothing but the
+// status code, which allows pre-rendering them once at startup
+func (c *Config) StaticPages() bool {
+	for _, r := range c.Routes {
+		if r.ShowDetails != nil && *r.ShowDetails {
+			return false
 		}
 	}
+	if len(c.CaptureHeaders.Request) > 0 || len(c.CaptureHeaders.Response) > 0 || len(c.CorrelationHeaders) > 0 {
+		return false
+	}
+	return !c.ShowDetails && !c.QRCode.Enabled && !c.CopyDetails.Enabled && c.StatusLink.URL == "" && c.Support.Email == "" && c.Support.URL == "" && !c.Beacon.Enabled && !c.ErrorTracking.Enabled && !c.CSP.Enabled && !c.IncidentBanner.Enabled && !c.StatusProvider.Enabled
+}
 
-	return cfg, nil
+// StatusText returns the custom texts of a status code: its own entry, or
+// the entry of its class ("4xx"/"5xx") unless builtin reports that the code
+// has a built-in message
+func (c *Config) StatusText(code int, builtin bool) (StatusText, bool) {
+	if text, ok := c.StatusCodes[strconv.Itoa(code)]; ok {
+		return text, true
+	}
+	if builtin {
+		return StatusText{}, false
+	}
+	class := "5xx"
+	if code < 500 {
+		class = "4xx"
+	}
+	text, ok := c.StatusCodes[class]
+	return text, ok
+}
+
+// RetryAfterFor returns the Retry-After seconds of a status code, from its
+// own retry_after entry or the entry of its class, or 0 when there is none
+func (c *Config) RetryAfterFor(code int) int {
+	if seconds, ok := c.RetryAfter[strconv.Itoa(code)]; ok {
+		return seconds
+	}
+	if code >= 500 {
+		return c.RetryAfter["5xx"]
+	}
+	return c.RetryAfter["4xx"]
+}
+
+// CustomStatusCodes returns the status codes with their own status_codes
+// entry, in ascending order
+func (c *Config) CustomStatusCodes() []int {
+	var codes []int
+	for key := range c.StatusCodes {
+		if code, err := strconv.Atoi(key); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// validate checks field values that YAML decoding alone cannot enforce
+func (c *Config) validate() error {
+	if c.BodyMode != BodyModeBuffer && c.BodyMode != BodyModeDiscard {
+		return fmt.Errorf("invalid body_mode %q: must be %q or %q", c.BodyMode, BodyModeBuffer, BodyModeDiscard)
+	}
+	if (c.Theme == ThemeRandom || c.Theme == ThemeRotate) && len(c.ThemePool) == 0 {
+		return fmt.Errorf("theme %q needs at least one theme in theme_pool", c.Theme)
+	}
+	if c.Theme == ThemeWeighted && len(c.ThemeWeights) == 0 {
+		return fmt.Errorf("theme %q needs at least one theme in theme_weights", c.Theme)
+	}
+	for name, weight := range c.ThemeWeights {
+		if themeMode(name) {
+			return fmt.Errorf("invalid theme_weights key %q: must name a theme", name)
+		}
+		if weight <= 0 {
+			return fmt.Errorf("invalid theme_weights.%s %d: must be positive", name, weight)
+		}
+	}
+	if c.Theme == ThemeWeighted && c.ThemeCookie == "" {
+		return fmt.Errorf("theme %q requires a theme_cookie", c.Theme)
+	}
+	if c.ThemeCookie != "" && !validCookieName(c.ThemeCookie) {
+		return fmt.Errorf("invalid theme_cookie %q: must be a cookie name", c.ThemeCookie)
+	}
+	for _, t := range [][2]string{{"theme_4xx", c.Theme4xx}, {"theme_5xx", c.Theme5xx}} {
+		if themeMode(t[1]) {
+			return fmt.Errorf("invalid %s %q: must name a theme", t[0], t[1])
+		}
+	}
+	for name, r := range c.Routes {
+		if name == "" {
+			return fmt.Errorf("invalid routes key %q: must be a route or virtual host name", name)
+		}
+		if themeMode(r.Theme) {
+			return fmt.Errorf("invalid routes.%s.theme %q: must name a theme", name, r.Theme)
+		}
+	}
+	if c.RouteMetadata.Enabled && c.RouteMetadata.Namespace == "" {
+		return fmt.Errorf("route_metadata.namespace is required when route metadata is enabled")
+	}
+	for _, theme := range c.RouteMetadata.Themes {
+		if theme == "" || themeMode(theme) {
+			return fmt.Errorf("invalid route_metadata.themes entry %q: must name a theme", theme)
+		}
+	}
+	for _, key := range c.Node.MetadataKeys {
+		if key == "" {
+			return fmt.Errorf("invalid node.metadata_keys: keys must not be empty")
+		}
+	}
+	switch c.LogFormat {
+	case logging.FormatText, logging.FormatJSON:
+	default:
+		return fmt.Errorf("invalid log_format %q: must be %q or %q", c.LogFormat, logging.FormatText, logging.FormatJSON)
+	}
+	switch c.ExternalAssets {
+	case ExternalAssetsAllow, ExternalAssetsReject, ExternalAssetsStrip:
+	default:
+		return fmt.Errorf("invalid external_assets %q: must be %q, %q or %q", c.ExternalAssets, ExternalAssetsAllow, ExternalAssetsReject, ExternalAssetsStrip)
+	}
+
+	switch c.TrafficDirection {
+	case TrafficAny, TrafficInbound, TrafficOutbound:
+	default:
+		return fmt.Errorf("invalid traffic_direction %q: must be %q, %q or %q", c.TrafficDirection, TrafficAny, TrafficInbound, TrafficOutbound)
+	}
+
+	switch c.DarkMode {
+	case DarkModeAuto, DarkModeAlways, DarkModeNever:
+	default:
+		return fmt.Errorf("invalid dark_mode %q: must be %q, %q or %q", c.DarkMode, DarkModeAuto, DarkModeAlways, DarkModeNever)
+	}
+	if !logging.ValidLevel(c.LogLevel) {
+		return fmt.Errorf("invalid log_level %q: must be %q, %q, %q or %q", c.LogLevel, logging.LevelDebug, logging.LevelInfo, logging.LevelWarn, logging.LevelError)
+	}
+	endpoints := map[string]string{}
+	for _, e := range []struct {
+		name    string
+		enabled bool
+		path    string
+	}{{"status", c.Status.Enabled, c.Status.Path}, {"control", c.Control.Enabled, c.Control.Path}, {"config_dump", c.ConfigDump.Enabled, c.ConfigDump.Path}, {"preview", c.Preview.Enabled, c.Preview.Path}, {"recent_errors", c.RecentErrors.Enabled, c.RecentErrors.Path}} {
+		if !e.enabled {
+			continue
+		}
+		if other, ok := endpoints[e.path]; ok {
+			return fmt.Errorf("%s.path and %s.path must differ", other, e.name)
+		}
+		endpoints[e.path] = e.name
+	}
+	if r := c.RecentErrors; r.Enabled && (r.Size < 1 || r.Size > 1000) {
+		return fmt.Errorf("invalid recent_errors.size %d: must be between 1 and 1000", r.Size)
+	}
+	if c.LogSampleRate < 1 {
+		return fmt.Errorf("invalid log_sample_rate %d: must be a positive integer", c.LogSampleRate)
+	}
+	if c.LogSummaryInterval < 0 {
+		return fmt.Errorf("invalid log_summary_interval %d: must be a non-negative integer", c.LogSummaryInterval)
+	}
+	if c.Beacon.Enabled {
+		if c.Beacon.Endpoint == "" {
+			return fmt.Errorf("beacon.endpoint is required when beacon is enabled")
+		}
+		if c.Beacon.Method != "beacon" && c.Beacon.Method != "image" {
+			return fmt.Errorf("invalid beacon.method %q: must be \"beacon\" or \"image\"", c.Beacon.Method)
+		}
+		if !isPath(c.Beacon.Endpoint) && c.ExternalAssets != ExternalAssetsAllow {
+			return fmt.Errorf("invalid beacon.endpoint %q: must be a path with external_assets %q", c.Beacon.Endpoint, c.ExternalAssets)
+		}
+	}
+	if c.ErrorTracking.Enabled {
+		if c.ErrorTracking.DSN == "" {
+			return fmt.Errorf("error_tracking.dsn is required when error tracking is enabled")
+		}
+		if c.ErrorTracking.ScriptURL == "" {
+			return fmt.Errorf("error_tracking.script_url is required when error tracking is enabled")
+		}
+		if u := c.ErrorTracking.ScriptURL; !isPath(u) && c.ExternalAssets != ExternalAssetsAllow {
+			return fmt.Errorf("invalid error_tracking.script_url %q: must be a path with external_assets %q", u, c.ExternalAssets)
+		}
+	}
+	if b := c.IncidentBanner; b.Enabled {
+		if b.Cluster == "" {
+			return fmt.Errorf("incident_banner.cluster is required when the incident banner is enabled")
+		}
+		if !strings.HasPrefix(b.Path, "/") {
+			return fmt.Errorf("invalid incident_banner.path %q: must start with /", b.Path)
+		}
+		if b.Interval < 1 || b.Timeout < 1 {
+			return fmt.Errorf("incident_banner.interval and incident_banner.timeout must be positive integers")
+		}
+	}
+	if p := c.StatusProvider; p.Enabled {
+		if _, err := statuspage.New(p.Type); err != nil {
+			return fmt.Errorf("invalid status_provider.type %q: must be %q, %q or %q", p.Type, statuspage.TypeStatuspage, statuspage.TypeCachet, statuspage.TypeJSON)
+		}
+		if p.Cluster == "" {
+			return fmt.Errorf("status_provider.cluster is required when the status provider is enabled")
+		}
+		if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+			return fmt.Errorf("invalid status_provider.path %q: must start with /", p.Path)
+		}
+		if p.Interval < 1 || p.Timeout < 1 {
+			return fmt.Errorf("status_provider.interval and status_provider.timeout must be positive integers")
+		}
+	}
+	if c.CDN.Enabled {
+		if !strings.HasPrefix(c.CDN.BaseURL, "https://") && !strings.HasPrefix(c.CDN.BaseURL, "http://") {
+			return fmt.Errorf("invalid cdn.base_url %q: must be an http or https URL", c.CDN.BaseURL)
+		}
+		if c.ExternalAssets != ExternalAssetsAllow {
+			return fmt.Errorf("cdn requires external_assets %q", ExternalAssetsAllow)
+		}
+	}
+	if f := c.Metadata.Favicon; f != "" && !strings.HasPrefix(f, "data:") && c.ExternalAssets != ExternalAssetsAllow {
+		return fmt.Errorf("invalid metadata.favicon: must be a data URI with external_assets %q", c.ExternalAssets)
+	}
+	// external_assets only inspects templates, so the logo of the corporate
+	// theme is checked here
+	if l := c.ThemeVariables["logo"]; l != "" && !strings.HasPrefix(l, "data:") && !isPath(l) && c.ExternalAssets != ExternalAssetsAllow {
+		return fmt.Errorf("invalid theme_variables.logo %q: must be a data URI or a path with external_assets %q", l, c.ExternalAssets)
+	}
+	if c.RenderCache.Enabled && (c.RenderCache.TTL < 1 || c.RenderCache.MaxEntries < 1) {
+		return fmt.Errorf("render_cache.ttl and render_cache.max_entries must be positive integers")
+	}
+	if c.Retry.Interval < 0 {
+		return fmt.Errorf("invalid retry.interval %d: must be a non-negative integer", c.Retry.Interval)
+	}
+	if c.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("invalid retry.max_attempts %d: must be a positive integer", c.Retry.MaxAttempts)
+	}
+	if u := c.QRCode.URL; u != "" && !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		return fmt.Errorf("invalid qr_code.url %q: must be an http or https URL", u)
+	}
+	if u := c.StatusLink.URL; u != "" && !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") && !isPath(u) {
+		return fmt.Errorf("invalid status_link.url %q: must be an http or https URL or a path", u)
+	}
+	for _, key := range c.StatusLink.Codes {
+		if !validStatusKey(key) {
+			return fmt.Errorf("invalid status_link.codes entry %q: must be a 4xx/5xx status code, \"4xx\" or \"5xx\"", key)
+		}
+	}
+	if e := c.Support.Email; e != "" && (!strings.Contains(e, "@") || strings.ContainsAny(e, " ?&<>\"")) {
+		return fmt.Errorf("invalid support.email %q: must be an email address", e)
+	}
+	if u := c.Support.URL; u != "" && !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") && !isPath(u) {
+		return fmt.Errorf("invalid support.url %q: must be an http or https URL or a path", u)
+	}
+	if p := c.Support.Phone; p != "" && !strings.ContainsAny(p, "0123456789") {
+		return fmt.Errorf("invalid support.phone %q: must be a phone number", p)
+	}
+	if u := c.CustomScript.URL; u != "" {
+		if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") && !isPath(u) {
+			return fmt.Errorf("invalid custom_script.url %q: must be an http or https URL or a path", u)
+		}
+		if !isPath(u) && c.ExternalAssets != ExternalAssetsAllow {
+			return fmt.Errorf("invalid custom_script.url %q: must be a path with external_assets %q", u, c.ExternalAssets)
+		}
+	}
+	if strings.Contains(strings.ToLower(c.CustomScript.Inline), "</script") {
+		return fmt.Errorf("invalid custom_script.inline: must not contain </script>")
+	}
+	if c.DebugEndpoints() && c.Debug.Token == "" {
+		return fmt.Errorf("debug.token is required when a debug endpoint is enabled")
+	}
+	for key := range c.StatusCodes {
+		if !validStatusKey(key) {
+			return fmt.Errorf("invalid status_codes key %q: must be a 4xx/5xx status code, \"4xx\" or \"5xx\"", key)
+		}
+	}
+	for key, seconds := range c.RetryAfter {
+		if !validStatusKey(key) {
+			return fmt.Errorf("invalid retry_after key %q: must be a 4xx/5xx status code, \"4xx\" or \"5xx\"", key)
+		}
+		if seconds < 1 {
+			return fmt.Errorf("invalid retry_after.%s %d: must be a positive integer", key, seconds)
+		}
+	}
+	for _, names := range [][]string{c.CaptureHeaders.Request, c.CaptureHeaders.Response} {
+		for _, name := range names {
+			if name == "" || strings.HasPrefix(name, ":") {
+				return fmt.Errorf("invalid capture_headers header %q", name)
+			}
+		}
+	}
+	for _, name := range c.CorrelationHeaders {
+		if name == "" || strings.HasPrefix(name, ":") {
+			return fmt.Errorf("invalid correlation_headers header %q", name)
+		}
+		// Correlation headers are echoed unmasked on the response
+		if strings.EqualFold(name, "x-forwarded-for") && (c.Privacy.MaskClientIP || c.Privacy.HideForwardedChain) {
+			return fmt.Errorf("invalid correlation_headers header %q: must not be x-forwarded-for with privacy.mask_client_ip or privacy.hide_forwarded_chain", name)
+		}
+	}
+	for name := range c.SecurityHeaders.Overrides {
+		if name == "" || strings.HasPrefix(name, ":") {
+			return fmt.Errorf("invalid security_headers.overrides header %q", name)
+		}
+	}
+	if c.Privacy.RequestIDLength < 0 {
+		return fmt.Errorf("invalid privacy.request_id_length %d: must be a non-negative integer", c.Privacy.RequestIDLength)
+	}
+	if _, err := c.Location(); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", c.Timezone, err)
+	}
+	if lang := c.Localization.DefaultLanguage; lang == "" || lang != strings.ToLower(lang) {
+		return fmt.Errorf("invalid localization.default_language %q: must be a lower-case language tag", lang)
+	}
+	if name := c.Localization.LocaleHeader; strings.HasPrefix(name, ":") {
+		return fmt.Errorf("invalid localization.locale_header %q: must not be a pseudo-header", name)
+	}
+	for lang, o := range c.Localization.Overrides {
+		if lang == "" || lang != strings.ToLower(lang) {
+			return fmt.Errorf("invalid localization.overrides language %q: must be a lower-case language tag", lang)
+		}
+		for _, table := range []map[string]string{o.Messages, o.Descriptions} {
+			for key := range table {
+				if !validStatusKey(key) {
+					return fmt.Errorf("invalid localization.overrides.%s key %q: must be a 4xx/5xx status code, \"4xx\" or \"5xx\"", lang, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validStatusKey reports whether key is an error status code or a class
+// fallback key
+func validStatusKey(key string) bool {
+	if key == "4xx" || key == "5xx" {
+		return true
+	}
+	code, err := strconv.Atoi(key)
+	return err == nil && code >= 400 && code < 600
+}
+
+// validCookieName reports whether name is an RFC 6265 cookie name, an HTTP
+// token
+func validCookieName(name string) bool {
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// isPath reports whether u is an absolute path on the same origin, rather
+// than a URL or a protocol-relative //host/path reference
+func isPath(u string) bool {
+	return strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//")
 }
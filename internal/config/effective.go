@@ -0,0 +1,54 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secrets in the effective configuration
+const Redacted = "REDACTED"
+
+// Effective returns the configuration with every default applied, keyed by
+// the YAML field names, for the effective configuration endpoint. The debug
+// token is redacted.
+func (c *Config) Effective() (map[string]any, error) {
+	effective := *c
+	if effective.Debug.Token != "" {
+		effective.Debug.Token = Redacted
+	}
+	plain, err := Plain(&effective)
+	if err != nil {
+		return nil, err
+	}
+	object, _ := plain.(map[string]any)
+	return object, nil
+}
+
+// Plain converts a configuration value to the maps, slices and scalars of
+// its YAML form, which encode to JSON with the YAML field names
+func Plain(v any) (any, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	var plain any
+	if err := yaml.Unmarshal(data, &plain); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Type URLs of the Any messages Envoy may wrap the plugin configuration in
+const (
+	typeStringValue = "type.googleapis.com/google.protobuf.StringValue"
+	typeStruct      = "type.googleapis.com/google.protobuf.Struct"
+	typeTypedStruct = "type.googleapis.com/xds.type.v3.TypedStruct"
+	typeUDPAStruct  = "type.googleapis.com/udpa.type.v1.TypedStruct"
+)
+
+// maxSafeInteger is the largest integer a double represents exactly
+const maxSafeInteger = 1 << 53
+
+// ParsePluginConfiguration parses the configuration passed by the host. A
+// google.protobuf.StringValue reaches the plugin as its YAML or JSON text,
+// while any other message, such as a google.protobuf.Struct (including ECDS
+// and Istio WasmPlugin pluginConfig) or an xds.type.v3.TypedStruct, arrives
+// as its protobuf JSON mapping wrapped in an "@type" envelope.
+func ParsePluginConfiguration(data []byte) (*Config, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return Parse(data)
+	}
+
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		// A YAML flow mapping rather than JSON
+		return Parse(data)
+	}
+	doc, err := unwrapAny(doc)
+	if err != nil {
+		return nil, err
+	}
+	if text, ok := doc.(string); ok {
+		return Parse([]byte(text))
+	}
+	normalized, err := json.Marshal(normalizeStruct(doc))
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	// JSON is YAML, so both formats share the defaults and validation
+	return Parse(normalized)
+}
+
+// unwrapAny strips the "@type" envelopes of doc, returning the configuration
+// object, or the text of a StringValue
+func unwrapAny(doc any) (any, error) {
+	for {
+		object, ok := doc.(map[string]any)
+		if !ok {
+			return doc, nil
+		}
+		typeURL, ok := object["@type"].(string)
+		if !ok {
+			return doc, nil
+		}
+		switch typeURL {
+		case typeStringValue, typeStruct, typeTypedStruct, typeUDPAStruct:
+			value, ok := object["value"]
+			if !ok {
+				// An empty Struct or StringValue
+				return map[string]any{}, nil
+			}
+			doc = value
+		default:
+			return nil, fmt.Errorf("invalid configuration type %q: must be %s, %s or %s", typeURL, typeStringValue, typeStruct, typeTypedStruct)
+		}
+	}
+}
+
+// normalizeStruct converts the numbers of a decoded protobuf Struct, which
+// are all doubles and may be printed as 30.0 or 3e+01, to integers where
+// they are whole, so they decode into int fields
+func normalizeStruct(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = normalizeStruct(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeStruct(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, err := v.Float64()
+		if err == nil && f == math.Trunc(f) && math.Abs(f) <= maxSafeInteger {
+			return int64(f)
+		}
+	}
+	return value
+}
@@ -0,0 +1,151 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package control keeps the runtime toggles of the control endpoint in
+// proxy-wasm shared data, so an update received by one worker thread reaches
+// every worker of the VM without a configuration push.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/logging"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const (
+	keyPrefix = "error_pages.control."
+	// casRetries bounds how often an update is retried when another worker
+	// updated the state concurrently
+	casRetries = 3
+)
+
+// State holds the runtime toggles. The zero value leaves the configured
+// behavior unchanged.
+type State struct {
+	// Maintenance answers every request with the 503 error page
+	Maintenance bool `json:"maintenance"`
+	// Banner is shown on 5xx pages, replacing the fetched incident banner
+	Banner string `json:"banner"`
+	// LogLevel replaces the configured log_level when set
+	LogLevel string `json:"log_level"`
+}
+
+// JSON renders the state as the control endpoint's response body
+func (s State) JSON() []byte {
+	b, _ := json.MarshalIndent(s, "", "  ")
+	return append(b, '\n')
+}
+
+// Apply updates the state from the query parameters of a control request:
+// maintenance=on|off, banner=<text> and log_level=<level>, where empty
+// values reset the banner and log level
+func (s *State) Apply(query url.Values) error {
+	for name, values := range query {
+		value := values[len(values)-1]
+		switch name {
+		case "maintenance":
+			switch value {
+			case "on":
+				s.Maintenance = true
+			case "off":
+				s.Maintenance = false
+			default:
+				on, err := strconv.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("invalid maintenance %q: must be \"on\" or \"off\"", value)
+				}
+				s.Maintenance = on
+			}
+		case "banner":
+			s.Banner = value
+		case "log_level":
+			if value != "" && !logging.ValidLevel(value) {
+				return fmt.Errorf("invalid log_level %q: must be %q, %q, %q or %q", value, logging.LevelDebug, logging.LevelInfo, logging.LevelWarn, logging.LevelError)
+			}
+			s.LogLevel = value
+		default:
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	return nil
+}
+
+// Store reads and updates the state of one control endpoint
+type Store struct {
+	key   string
+	cas   uint32 // version of state, 0 before the first load
+	state State
+}
+
+// NewStore returns the store of the control endpoint at path. Plugin
+// instances with the same control path share their state.
+func NewStore(path string) *Store {
+	return &Store{key: keyPrefix + path}
+}
+
+// Load returns the current state. It only decodes the shared data when
+// another worker changed it since the last call, and reports whether it did.
+func (s *Store) Load() (State, bool) {
+	raw, cas, err := proxywasm.GetSharedData(s.key)
+	if err != nil || cas == s.cas {
+		return s.state, false
+	}
+	var state State
+	if err := json.Unmarshal(raw, &state); err != nil {
+		proxywasm.LogWarnf("ignoring invalid control state: %v", err)
+		return s.state, false
+	}
+	s.state, s.cas = state, cas
+	return state, true
+}
+
+// Update applies the query parameters of a control request to the shared
+// state and returns the result
+func (s *Store) Update(query url.Values) (State, error) {
+	for attempt := 0; attempt < casRetries; attempt++ {
+		raw, cas, err := proxywasm.GetSharedData(s.key)
+		if err != nil && !errors.Is(err, types.ErrorStatusNotFound) {
+			return State{}, err
+		}
+		var state State
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &state); err != nil {
+				return State{}, err
+			}
+		}
+		if err := state.Apply(query); err != nil {
+			return State{}, err
+		}
+		value, err := json.Marshal(state)
+		if err != nil {
+			return State{}, err
+		}
+		err = proxywasm.SetSharedData(s.key, value, cas)
+		if errors.Is(err, types.ErrorStatusCasMismatch) {
+			continue
+		}
+		if err != nil {
+			return State{}, err
+		}
+		return state, nil
+	}
+	return State{}, errors.New("control state changed concurrently, retry")
+}
@@ -0,0 +1,91 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package csp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// NoncePlaceholder is replaced with the per-response nonce in policies
+const NoncePlaceholder = "{nonce}"
+
+// Policy builds the Content-Security-Policy header of rendered pages
+type Policy struct {
+	template string
+}
+
+// New returns custom when set, or a strict default policy: inline scripts and
+// styles need the nonce, stylesheets, images and connections are limited to
+// the given origins, plus data URIs for images.
+func New(custom string, imgSrc, connectSrc, styleSrc []string) *Policy {
+	if custom != "" {
+		return &Policy{template: custom}
+	}
+	directives := []string{
+		"default-src 'none'",
+		"script-src 'nonce-" + NoncePlaceholder + "'",
+		strings.Join(append([]string{"style-src 'nonce-" + NoncePlaceholder + "'"}, unique(styleSrc)...), " "),
+		"img-src " + strings.Join(append([]string{"data:"}, unique(imgSrc)...), " "),
+	}
+	if connect := unique(connectSrc); len(connect) > 0 {
+		directives = append(directives, "connect-src "+strings.Join(connect, " "))
+	}
+	directives = append(directives, "base-uri 'none'", "form-action 'none'")
+	return &Policy{template: strings.Join(directives, "; ")}
+}
+
+// Header returns the policy for a response with the given nonce
+func (p *Policy) Header(nonce string) string {
+	return strings.ReplaceAll(p.template, NoncePlaceholder, nonce)
+}
+
+// Nonce returns a fresh random nonce
+func Nonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// Origin returns the scheme and host of an absolute URL, the host alone of a
+// protocol-relative one, or "" when it has none
+func Origin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if u.Scheme == "" {
+		return u.Host
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func unique(sources []string) []string {
+	seen := make(map[string]bool, len(sources))
+	result := make([]string, 0, len(sources))
+	for _, s := range sources {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,255 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Supported log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Log levels of per-request entries, from the most to the least verbose
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// levels orders the log levels by verbosity
+var levels = []string{LevelDebug, LevelInfo, LevelWarn, LevelError}
+
+// ValidLevel reports whether level is a supported log level
+func ValidLevel(level string) bool {
+	return slices.Contains(levels, level)
+}
+
+// Actions recorded in per-request log entries
+const (
+	ActionResponse      = "response"
+	ActionIntercept     = "intercept"
+	ActionReplace       = "replace"
+	ActionRenderFailed  = "render_failed"
+	ActionReplaceFailed = "replace_failed"
+	ActionSummary       = "summary"
+	ActionThemeFallback = "theme_fallback"
+	ActionDone          = "done"
+)
+
+// Outcomes of a stream, recorded by the done entry
+const (
+	// OutcomePassedThrough left the upstream response unchanged
+	OutcomePassedThrough = "passed_through"
+	// OutcomeReplaced replaced the response with an error page
+	OutcomeReplaced = "replaced"
+	// OutcomeRenderFailed intercepted the response but failed to render,
+	// and served the fallback page instead
+	OutcomeRenderFailed = "render_failed"
+	// OutcomeReplaceFailed rendered the page but failed to replace the body,
+	// and served the fallback page instead
+	OutcomeReplaceFailed = "replace_failed"
+	// OutcomeNotReplaced intercepted the response but never saw its body,
+	// e.g. headers-only responses or streams reset by the client
+	OutcomeNotReplaced = "not_replaced"
+	// OutcomeNoResponse ended before any response headers
+	OutcomeNoResponse = "no_response"
+	// OutcomeLocal was answered by the plugin itself
+	OutcomeLocal = "local"
+)
+
+// Event holds the fields emitted with every per-request log entry
+type Event struct {
+	RequestID string `json:"request_id"`
+	Host      string `json:"host"`
+	Path      string `json:"path"`
+	Code      int    `json:"code"`
+	Theme     string `json:"theme"`
+	Action    string `json:"action"`
+	TraceID   string `json:"trace_id,omitempty"`
+	SpanID    string `json:"span_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Outcome   string `json:"outcome,omitempty"`
+	// Attempts is the number of upstream attempts, when Envoy retried
+	Attempts int `json:"attempts,omitempty"`
+	// CodeDetails is Envoy's reason for the response code, e.g.
+	// upstream_response_timeout
+	CodeDetails string `json:"code_details,omitempty"`
+	// Correlation holds the correlation IDs of the request by header name
+	Correlation map[string]string `json:"correlation,omitempty"`
+}
+
+// String renders the event as a single-line JSON object
+func (e *Event) String() string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf(`{"action":%q,"error":%q}`, e.Action, err.Error())
+	}
+	return string(b)
+}
+
+// Text renders the event as a single logfmt-style line of key=value pairs,
+// omitting empty fields
+func (e *Event) Text() string {
+	var b strings.Builder
+	b.WriteString(e.Action)
+	field := func(key, value string) {
+		if value == "" {
+			return
+		}
+		b.WriteString(" " + key + "=")
+		if strings.ContainsAny(value, " \t\"=") {
+			b.WriteString(strconv.Quote(value))
+		} else {
+			b.WriteString(value)
+		}
+	}
+	if e.Code != 0 {
+		field("code", strconv.Itoa(e.Code))
+	}
+	field("theme", e.Theme)
+	field("host", e.Host)
+	field("path", e.Path)
+	field("request_id", e.RequestID)
+	field("trace_id", e.TraceID)
+	field("span_id", e.SpanID)
+	field("error", e.Error)
+	field("outcome", e.Outcome)
+	if e.Attempts != 0 {
+		field("attempts", strconv.Itoa(e.Attempts))
+	}
+	field("code_details", e.CodeDetails)
+	for _, name := range slices.Sorted(maps.Keys(e.Correlation)) {
+		field(name, e.Correlation[name])
+	}
+	return b.String()
+}
+
+// Summary holds the periodic interception counts reported by the sampler
+type Summary struct {
+	Action      string `json:"action"`
+	Interval    int    `json:"interval_seconds"`
+	Intercepted uint64 `json:"intercepted"`
+	Suppressed  uint64 `json:"suppressed"`
+}
+
+// Logger writes per-request log entries either as free-form text or as
+// structured JSON lines, depending on the configured format. Entries below
+// the logger's level are dropped before reaching the host.
+type Logger struct {
+	JSON  bool
+	level int // index into levels
+}
+
+// New creates a logger for the given format ("text" or "json") that logs
+// every level
+func New(format string) *Logger {
+	return &Logger{JSON: format == FormatJSON}
+}
+
+// SetLevel drops entries below level from then on. Unknown levels are
+// ignored.
+func (l *Logger) SetLevel(level string) {
+	if i := slices.Index(levels, level); i >= 0 {
+		l.level = i
+	}
+}
+
+// Level returns the level set by SetLevel
+func (l *Logger) Level() string {
+	return levels[l.level]
+}
+
+// enabled reports whether entries of level are logged
+func (l *Logger) enabled(level string) bool {
+	return slices.Index(levels, level) >= l.level
+}
+
+// Debugf logs the event at debug level
+func (l *Logger) Debugf(e *Event, format string, args ...any) {
+	if !l.enabled(LevelDebug) {
+		return
+	}
+	if l.JSON {
+		proxywasm.LogDebug(e.String())
+		return
+	}
+	proxywasm.LogDebugf(format, args...)
+}
+
+// Infof logs the event at info level
+func (l *Logger) Infof(e *Event, format string, args ...any) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	if l.JSON {
+		proxywasm.LogInfo(e.String())
+		return
+	}
+	proxywasm.LogInfof(format, args...)
+}
+
+// Warn logs the event at warn level in structured form regardless of the
+// configured format, so failures can be alerted on reliably
+func (l *Logger) Warn(e *Event) {
+	if !l.enabled(LevelWarn) {
+		return
+	}
+	if l.JSON {
+		proxywasm.LogWarn(e.String())
+		return
+	}
+	proxywasm.LogWarn(e.Text())
+}
+
+// Errorf logs the event at error level
+func (l *Logger) Errorf(e *Event, format string, args ...any) {
+	if !l.enabled(LevelError) {
+		return
+	}
+	if l.JSON {
+		proxywasm.LogError(e.String())
+		return
+	}
+	proxywasm.LogErrorf(format, args...)
+}
+
+// Summary logs the interception counts accumulated over the last interval
+func (l *Logger) Summary(intervalSeconds int, intercepted, suppressed uint64) {
+	if !l.enabled(LevelInfo) {
+		return
+	}
+	if l.JSON {
+		b, _ := json.Marshal(&Summary{
+			Action:      ActionSummary,
+			Interval:    intervalSeconds,
+			Intercepted: intercepted,
+			Suppressed:  suppressed,
+		})
+		proxywasm.LogInfo(string(b))
+		return
+	}
+	proxywasm.LogInfof("intercepted %d error responses in the last %ds (%d not logged due to sampling)", intercepted, intervalSeconds, suppressed)
+}
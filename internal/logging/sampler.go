@@ -0,0 +1,50 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+// Sampler keeps log volume bounded during error storms by letting only one
+// in every Rate interceptions through, while counting the rest so they can
+// be reported periodically.
+type Sampler struct {
+	Rate uint64
+
+	seen       uint64
+	suppressed uint64
+}
+
+// NewSampler creates a sampler that logs 1 of every rate interceptions
+func NewSampler(rate int) *Sampler {
+	if rate < 1 {
+		rate = 1
+	}
+	return &Sampler{Rate: uint64(rate)}
+}
+
+// Sample records an interception and reports whether it should be logged
+func (s *Sampler) Sample() bool {
+	s.seen++
+	if s.Rate <= 1 || s.seen%s.Rate == 1 {
+		return true
+	}
+	s.suppressed++
+	return false
+}
+
+// Flush returns the counts accumulated since the last flush and resets them
+func (s *Sampler) Flush() (seen, suppressed uint64) {
+	seen, suppressed = s.seen, s.suppressed
+	s.seen, s.suppressed = 0, 0
+	return seen, suppressed
+}
@@ -0,0 +1,92 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Metric names, exposed by Envoy under the wasmcustom stats prefix
+const (
+	InterceptedName     = "error_pages.intercepted"
+	ThemeFallbacksName  = "error_pages.theme_fallbacks"
+	RenderFailuresName  = "error_pages.render_failures"
+	ReplaceFailuresName = "error_pages.replace_failures"
+	FallbackPagesName   = "error_pages.fallback_pages"
+	ReplacedName        = "error_pages.replaced"
+	PassedThroughName   = "error_pages.passed_through"
+	NotReplacedName     = "error_pages.not_replaced"
+)
+
+// Metrics holds the counters exported by the plugin
+type Metrics struct {
+	Intercepted     proxywasm.MetricCounter
+	ThemeFallbacks  proxywasm.MetricCounter
+	RenderFailures  proxywasm.MetricCounter
+	ReplaceFailures proxywasm.MetricCounter
+	FallbackPages   proxywasm.MetricCounter
+	Replaced        proxywasm.MetricCounter
+	PassedThrough   proxywasm.MetricCounter
+	NotReplaced     proxywasm.MetricCounter
+	// Variants counts the interceptions of each theme variant, see
+	// DefineVariants
+	Variants map[string]proxywasm.MetricCounter
+}
+
+// Define registers the plugin's metrics with the host
+func Define() *Metrics {
+	return &Metrics{
+		Intercepted:     proxywasm.DefineCounterMetric(InterceptedName),
+		ThemeFallbacks:  proxywasm.DefineCounterMetric(ThemeFallbacksName),
+		RenderFailures:  proxywasm.DefineCounterMetric(RenderFailuresName),
+		ReplaceFailures: proxywasm.DefineCounterMetric(ReplaceFailuresName),
+		FallbackPages:   proxywasm.DefineCounterMetric(FallbackPagesName),
+		Replaced:        proxywasm.DefineCounterMetric(ReplacedName),
+		PassedThrough:   proxywasm.DefineCounterMetric(PassedThroughName),
+		NotReplaced:     proxywasm.DefineCounterMetric(NotReplacedName),
+	}
+}
+
+// VariantInterceptedName returns the name of the interception counter of a
+// theme variant
+func VariantInterceptedName(theme string) string {
+	return "error_pages.variants." + theme + ".intercepted"
+}
+
+// DefineVariants registers the interception counters of theme variants
+func (m *Metrics) DefineVariants(themes []string) {
+	m.Variants = make(map[string]proxywasm.MetricCounter, len(themes))
+	for _, theme := range themes {
+		m.Variants[theme] = proxywasm.DefineCounterMetric(VariantInterceptedName(theme))
+	}
+}
+
+// Counters returns the current value of every counter keyed by metric name
+func (m *Metrics) Counters() map[string]uint64 {
+	counters := map[string]uint64{
+		InterceptedName:     m.Intercepted.Value(),
+		ThemeFallbacksName:  m.ThemeFallbacks.Value(),
+		RenderFailuresName:  m.RenderFailures.Value(),
+		ReplaceFailuresName: m.ReplaceFailures.Value(),
+		FallbackPagesName:   m.FallbackPages.Value(),
+		ReplacedName:        m.Replaced.Value(),
+		PassedThroughName:   m.PassedThrough.Value(),
+		NotReplacedName:     m.NotReplaced.Value(),
+	}
+	for theme, counter := range m.Variants {
+		counters[VariantInterceptedName(theme)] = counter.Value()
+	}
+	return counters
+}
@@ -0,0 +1,130 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/status"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/statuspage"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// maxComponentFailures is the number of consecutive failed fetches after
+// which the affected components are dropped rather than shown stale
+const maxComponentFailures = 3
+
+// componentStatus holds the affected components of the latest status page
+// response. The zero value shows none.
+type componentStatus struct {
+	affected []errorpages.Component
+	checksum string // identifies the components in render cache keys
+}
+
+// fetchComponents requests the component statuses from the configured
+// status API, unless the previous request is still in flight
+func (ctx *pluginContext) fetchComponents() {
+	if ctx.componentsPending {
+		return
+	}
+	p := ctx.config.StatusProvider
+	authority := p.Authority
+	if authority == "" {
+		authority = p.Cluster
+	}
+	path := p.Path
+	if path == "" {
+		path = ctx.statusProvider.DefaultPath()
+	}
+	headers := [][2]string{
+		{":method", "GET"},
+		{":path", path},
+		{":authority", authority},
+		{"accept", "application/json"},
+	}
+	if _, err := proxywasm.DispatchHttpCall(p.Cluster, headers, nil, nil, uint32(p.Timeout)*1000, ctx.onComponentsResponse); err != nil {
+		ctx.componentsFailed("failed to fetch component statuses: %v", err)
+		return
+	}
+	ctx.componentsPending = true
+}
+
+// onComponentsResponse updates the affected components from the status
+// API. Failed fetches keep the current ones until the next fetch, for up to
+// maxComponentFailures fetches. Components with an unknown status are
+// skipped.
+func (ctx *pluginContext) onComponentsResponse(numHeaders, bodySize, numTrailers int) {
+	ctx.componentsPending = false
+
+	code := ""
+	headers, err := proxywasm.GetHttpCallResponseHeaders()
+	if err == nil {
+		for _, h := range headers {
+			if h[0] == ":status" {
+				code = h[1]
+			}
+		}
+	}
+	if code != "200" {
+		ctx.componentsFailed("failed to fetch component statuses: status %q", code)
+		return
+	}
+	body, err := proxywasm.GetHttpCallResponseBody(0, bodySize)
+	if err != nil {
+		ctx.componentsFailed("failed to read component statuses: %v", err)
+		return
+	}
+	components, skipped, err := ctx.statusProvider.Parse(body)
+	if err != nil {
+		ctx.componentsFailed("invalid component statuses: %v", err)
+		return
+	}
+	for _, s := range skipped {
+		proxywasm.LogWarnf("skipping component status: %s", s)
+	}
+	ctx.componentFailures = 0
+
+	var current componentStatus
+	if affected := statuspage.Affected(components, ctx.config.StatusProvider.Components); len(affected) > 0 {
+		var b strings.Builder
+		current.affected = make([]errorpages.Component, len(affected))
+		for i, c := range affected {
+			current.affected[i] = errorpages.Component{Name: c.Name, Status: c.Status.Label()}
+			b.WriteString(c.Name + "=" + string(c.Status) + ";")
+		}
+		current.checksum = status.Checksum([]byte(b.String()))[:16]
+	}
+	if current.checksum != ctx.components.checksum {
+		if len(current.affected) > 0 {
+			proxywasm.LogInfof("Affected components updated: %v", current.affected)
+		} else {
+			proxywasm.LogInfof("All components operational")
+		}
+	}
+	ctx.components = current
+}
+
+// componentsFailed logs a failed fetch of the component statuses and drops
+// the affected components once too many fetches in a row failed
+func (ctx *pluginContext) componentsFailed(format string, args ...any) {
+	proxywasm.LogWarnf(format, args...)
+	ctx.componentFailures++
+	if ctx.componentFailures == maxComponentFailures && len(ctx.components.affected) > 0 {
+		proxywasm.LogWarnf("Dropping affected components after %d failed fetches", ctx.componentFailures)
+		ctx.components = componentStatus{}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/control"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/csp"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/logging"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/status"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// syncControl picks up changes of the runtime toggles made through the
+// control endpoint of any worker
+func (ctx *pluginContext) syncControl() {
+	state, changed := ctx.control.Load()
+	if !changed {
+		return
+	}
+	ctx.controlState = state
+	ctx.controlBanner = incidentBanner{}
+	if message := strings.TrimSpace(state.Banner); message != "" {
+		ctx.controlBanner = incidentBanner{message: message, checksum: status.Checksum([]byte(message))[:16]}
+	}
+	level := ctx.config.LogLevel
+	if state.LogLevel != "" {
+		level = state.LogLevel
+	}
+	ctx.logger.SetLevel(level)
+	proxywasm.LogInfof("Runtime control state: maintenance=%v, banner=%q, log_level=%s", state.Maintenance, ctx.controlBanner.message, level)
+}
+
+// banner returns the banner of 5xx pages: the one set through the control
+// endpoint, else the fetched incident banner
+func (ctx *pluginContext) banner() incidentBanner {
+	if ctx.controlBanner.message != "" {
+		return ctx.controlBanner
+	}
+	return ctx.incident
+}
+
+// serveControl answers the control endpoint: GET returns the runtime
+// toggles, POST updates them from the query parameters
+func (ctx *httpContext) serveControl() types.Action {
+	method, _ := proxywasm.GetHttpRequestHeader(":method")
+	state := ctx.plugin.controlState
+	switch method {
+	case "GET":
+	case "POST":
+		query, err := url.ParseQuery(requestQuery(ctx.originalURI))
+		if err == nil {
+			err = (&control.State{}).Apply(query)
+		}
+		if err != nil {
+			return ctx.sendControlResponse(400, []byte(err.Error()+"\n"))
+		}
+		if state, err = ctx.plugin.control.Update(query); err != nil {
+			proxywasm.LogErrorf("failed to update control state: %v", err)
+			return ctx.sendControlResponse(500, []byte(err.Error()+"\n"))
+		}
+		proxywasm.LogWarnf("control state updated: %s", requestQuery(ctx.originalURI))
+		ctx.plugin.syncControl()
+	default:
+		return ctx.sendControlResponse(405, []byte("method not allowed\n"))
+	}
+	return ctx.sendControlResponse(200, state.JSON())
+}
+
+// sendControlResponse sends a response of the control endpoint, JSON on
+// success and plain text otherwise
+func (ctx *httpContext) sendControlResponse(code uint32, body []byte) types.Action {
+	contentType := "text/plain"
+	if code == 200 {
+		contentType = "application/json"
+	}
+	headers := [][2]string{
+		{"content-type", contentType},
+		{"cache-control", "no-store"},
+	}
+	if code == 405 {
+		headers = append(headers, [2]string{"allow", "GET, POST"})
+	}
+	if err := proxywasm.SendHttpResponse(code, headers, body, -1); err != nil {
+		proxywasm.LogErrorf("failed to send control response: %v", err)
+	}
+	return types.ActionPause
+}
+
+// serveMaintenance answers the request with the 503 error page while
+// maintenance mode is switched on, without contacting the upstream. The
+// request is passed upstream if the page fails to render.
+func (ctx *httpContext) serveMaintenance() types.Action {
+	ctx.statusCode = "503"
+	ctx.theme = ctx.plugin.pickTheme(ctx.statusCode, ctx.route, ctx.variant)
+
+	headers := ctx.pageHeaders(503)
+	page, err := ctx.renderPage(503)
+	if err != nil {
+		ctx.plugin.metrics.RenderFailures.Increment(1)
+		ctx.plugin.logger.Warn(ctx.event(logging.ActionRenderFailed, err))
+		page = ctx.plugin.fallbackPage(503, ctx.lang)
+		ctx.plugin.metrics.FallbackPages.Increment(1)
+	}
+	ctx.localReply = true
+	if err := proxywasm.SendHttpResponse(503, headers, page, -1); err != nil {
+		proxywasm.LogErrorf("failed to send maintenance page: %v", err)
+	}
+	return types.ActionPause
+}
+
+// pageHeaders returns the headers of an error page the plugin answers with
+// itself, matching those set on intercepted responses. It sets the CSP nonce
+// of the page.
+func (ctx *httpContext) pageHeaders(code int) [][2]string {
+	headers := [][2]string{
+		{"content-type", "text/html; charset=utf-8"},
+		{"cache-control", "no-store"},
+	}
+	if ctx.plugin.cspPolicy != nil {
+		nonce, err := csp.Nonce()
+		if err != nil {
+			proxywasm.LogWarnf("failed to generate CSP nonce: %v", err)
+		} else {
+			ctx.nonce = nonce
+			headers = append(headers, [2]string{"content-security-policy", ctx.plugin.cspPolicy.Header(nonce)})
+		}
+	}
+	headers = append(headers, ctx.plugin.securityHeaders...)
+	if ctx.plugin.config.NoIndex {
+		headers = append(headers, [2]string{"x-robots-tag", "noindex"})
+	}
+	if ctx.plugin.config.Localization.Enabled {
+		// The page language is negotiated, see OnHttpResponseHeaders
+		headers = append(headers, [2]string{"vary", "accept-language"})
+	}
+	if cookie := ctx.variantCookie(); cookie != "" {
+		headers = append(headers, [2]string{"set-cookie", cookie})
+	}
+	headers = append(headers, ctx.correlation...)
+	if seconds := ctx.plugin.config.RetryAfterFor(code); seconds > 0 {
+		headers = append(headers, [2]string{"retry-after", strconv.Itoa(seconds)})
+	}
+	return headers
+}
@@ -0,0 +1,40 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import "github.com/ishioni/envoy-wasm-error-pages/internal/config"
+
+// Values of the listener_direction property, Envoy's TrafficDirection enum
+const (
+	listenerInbound  = 1
+	listenerOutbound = 2
+)
+
+// directionMatches reports whether the listener of the request carries the
+// traffic direction the plugin handles. Listeners without a direction, such
+// as those of gateways, only match traffic_direction: any.
+func (ctx *pluginContext) directionMatches() bool {
+	var want int64
+	switch ctx.config.TrafficDirection {
+	case config.TrafficInbound:
+		want = listenerInbound
+	case config.TrafficOutbound:
+		want = listenerOutbound
+	default:
+		return true
+	}
+	direction, ok := intProperty("listener_direction")
+	return ok && direction == want
+}
@@ -0,0 +1,136 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/status"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// incidentBanner is the banner of the latest incident document. The zero
+// value shows no banner.
+type incidentBanner struct {
+	message    string
+	url        string
+	resolvesAt int64  // Unix time, 0 if unknown
+	checksum   string // identifies the banner in render cache keys
+}
+
+// fetchIncident requests the incident document from the configured cluster,
+// unless the previous request is still in flight
+func (ctx *pluginContext) fetchIncident() {
+	if ctx.incidentPending {
+		return
+	}
+	b := ctx.config.IncidentBanner
+	authority := b.Authority
+	if authority == "" {
+		authority = b.Cluster
+	}
+	headers := [][2]string{
+		{":method", "GET"},
+		{":path", b.Path},
+		{":authority", authority},
+		{"accept", "application/json"},
+	}
+	if _, err := proxywasm.DispatchHttpCall(b.Cluster, headers, nil, nil, uint32(b.Timeout)*1000, ctx.onIncidentResponse); err != nil {
+		proxywasm.LogWarnf("failed to fetch incident document: %v", err)
+		return
+	}
+	ctx.incidentPending = true
+}
+
+// onIncidentResponse updates the banner from the incident document. 204 and
+// 404 responses clear it; failed fetches keep the current banner.
+func (ctx *pluginContext) onIncidentResponse(numHeaders, bodySize, numTrailers int) {
+	ctx.incidentPending = false
+
+	code := ""
+	headers, err := proxywasm.GetHttpCallResponseHeaders()
+	if err == nil {
+		for _, h := range headers {
+			if h[0] == ":status" {
+				code = h[1]
+			}
+		}
+	}
+	var banner incidentBanner
+	switch code {
+	case "200":
+		body, err := proxywasm.GetHttpCallResponseBody(0, bodySize)
+		if err != nil && bodySize > 0 {
+			proxywasm.LogWarnf("failed to read incident document: %v", err)
+			return
+		}
+		if banner, err = parseIncident(body); err != nil {
+			proxywasm.LogWarnf("invalid incident document: %v", err)
+			return
+		}
+	case "204", "404":
+	default:
+		proxywasm.LogWarnf("failed to fetch incident document: status %q", code)
+		return
+	}
+
+	if banner.checksum != ctx.incident.checksum {
+		if banner.message != "" {
+			proxywasm.LogInfof("Incident banner updated: %s", banner.message)
+		} else {
+			proxywasm.LogInfof("Incident banner cleared")
+		}
+	}
+	ctx.incident = banner
+}
+
+// parseIncident reads an incident document such as
+//
+//	{"message": "...", "expected_resolution": "2024-05-01T14:00:00Z", "url": "https://status.example.com"}
+//
+// A document without a message clears the banner.
+func parseIncident(body []byte) (incidentBanner, error) {
+	var doc struct {
+		Message            string `json:"message"`
+		ExpectedResolution string `json:"expected_resolution"`
+		URL                string `json:"url"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return incidentBanner{}, err
+	}
+	banner := incidentBanner{message: strings.TrimSpace(doc.Message)}
+	if banner.message == "" {
+		return incidentBanner{}, nil
+	}
+	if doc.URL != "" {
+		if !strings.HasPrefix(doc.URL, "https://") && !strings.HasPrefix(doc.URL, "http://") {
+			return incidentBanner{}, fmt.Errorf("url %q must be an http or https URL", doc.URL)
+		}
+		banner.url = doc.URL
+	}
+	if doc.ExpectedResolution != "" {
+		t, err := time.Parse(time.RFC3339, doc.ExpectedResolution)
+		if err != nil {
+			return incidentBanner{}, fmt.Errorf("expected_resolution %q must be an RFC 3339 timestamp", doc.ExpectedResolution)
+		}
+		banner.resolvesAt = t.Unix()
+	}
+	banner.checksum = status.Checksum(body)[:16]
+	return banner, nil
+}
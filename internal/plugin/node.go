@@ -0,0 +1,78 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// nodeDetails describe the Envoy node, which doesn't change for the lifetime
+// of the plugin
+type nodeDetails struct {
+	id       string
+	cluster  string
+	metadata map[string]string
+	servedBy string
+}
+
+// node returns the details of the Envoy node, read on first use
+func (ctx *pluginContext) node() *nodeDetails {
+	if ctx.nodeDetails != nil {
+		return ctx.nodeDetails
+	}
+	node := &nodeDetails{
+		id:       nodeProperty("id"),
+		cluster:  nodeProperty("cluster"),
+		metadata: make(map[string]string, len(ctx.config.Node.MetadataKeys)),
+	}
+	var values []string
+	for _, key := range ctx.config.Node.MetadataKeys {
+		if value := nodeProperty("metadata", key); value != "" {
+			node.metadata[key] = value
+			values = append(values, value)
+		}
+	}
+	node.servedBy = node.id
+	if node.servedBy == "" {
+		node.servedBy = node.cluster
+	}
+	if len(values) > 0 {
+		node.servedBy = strings.TrimSpace(node.servedBy + " (" + strings.Join(values, ", ") + ")")
+	}
+	ctx.nodeDetails = node
+	return node
+}
+
+// setNode fills in the node details of data
+func (ctx *pluginContext) setNode(data *errorpages.TemplateData) {
+	node := ctx.node()
+	data.NodeID = node.id
+	data.NodeCluster = node.cluster
+	data.NodeMetadata = node.metadata
+	data.ServedBy = node.servedBy
+}
+
+// nodeProperty reads a property of the Envoy node, empty when it isn't set
+func nodeProperty(path ...string) string {
+	value, err := proxywasm.GetProperty(append([]string{"node"}, path...))
+	if err != nil {
+		return ""
+	}
+	return string(value)
+}
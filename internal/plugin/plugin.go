@@ -0,0 +1,1194 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plugin implements the error pages proxy-wasm plugin. The wasm
+// entrypoint registers it with NewVMContext; the simulator package runs the
+// same contexts on the proxytest host.
+package plugin
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/config"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/control"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/csp"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/logging"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/metrics"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/privacy"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/recent"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/rendercache"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/status"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/statuspage"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/tracing"
+	"github.com/ishioni/envoy-wasm-error-pages/l10n"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// version and defaultConfig are set by NewVMContext and shared by every
+// plugin context of the VM
+var (
+	version       string
+	defaultConfig []byte
+
+	// renderBuf is reused for every rendered page. The VM is single-threaded
+	// and ReplaceHttpResponseBody copies the page to the host, so the buffer
+	// is free again as soon as the body has been replaced.
+	renderBuf bytes.Buffer
+)
+
+// NewVMContext returns the plugin's VM context. defaultConfig is the YAML
+// configuration used when the host doesn't pass a plugin configuration.
+func NewVMContext(pluginVersion string, defaultConfigYAML []byte) types.VMContext {
+	version = pluginVersion
+	defaultConfig = defaultConfigYAML
+	return &vmContext{}
+}
+
+// vmContext implements types.VMContext.
+type vmContext struct {
+	types.DefaultVMContext
+}
+
+// NewPluginContext implements types.VMContext.
+func (*vmContext) NewPluginContext(contextID uint32) types.PluginContext {
+	return &pluginContext{}
+}
+
+// pluginContext implements types.PluginContext. It holds the configuration
+// of one plugin instance and everything derived from it at plugin start, so
+// filters with different configurations can share a VM.
+type pluginContext struct {
+	types.DefaultPluginContext
+
+	config          *config.Config
+	handlers        map[string]*errorpages.Handler // by theme name, see loadThemes
+	themeNames      []string                       // themes in configured order, see pickTheme
+	themeWeights    []int                          // weights of themeNames with theme: weighted
+	theme4xx        string                         // theme of client errors, if configured
+	theme5xx        string                         // theme of server errors, if configured
+	routes          map[string]config.Route        // route rules by route or virtual host name
+	metadataThemes  map[string]string              // themes selected by route metadata, see metadataTheme
+	logger          *logging.Logger
+	sampler         *logging.Sampler
+	metrics         *metrics.Metrics
+	configChecksum  string
+	renderCache     *rendercache.Cache
+	catalog         *l10n.Catalog
+	l10nScript      string
+	location        *time.Location
+	l10nBundles     map[int]string // language switcher bundles by status code
+	cspPolicy       *csp.Policy
+	privacyOptions  privacy.Options
+	securityHeaders [][2]string
+
+	// debugEndpoints maps the paths of the enabled debug endpoints to their
+	// handlers, see serveDebug
+	debugEndpoints map[string]func(*httpContext) types.Action
+
+	// OnTick runs every tickPeriod seconds, see tickPeriod
+	tickPeriod int
+	ticks      int
+
+	incident        incidentBanner // shown on 5xx pages, see fetchIncident
+	incidentPending bool           // an incident document fetch is in flight
+
+	statusProvider    statuspage.Provider
+	components        componentStatus // shown on 5xx pages, see fetchComponents
+	componentsPending bool            // a component status fetch is in flight
+	componentFailures int             // consecutive failed fetches, see componentsFailed
+
+	control       *control.Store // runtime toggles, nil unless the control endpoint is enabled
+	controlState  control.State  // last loaded runtime toggles, see syncControl
+	controlBanner incidentBanner // banner set through the control endpoint
+
+	recentErrors *recent.Buffer // last intercepted errors, nil unless the recent errors endpoint is enabled
+
+	nodeDetails *nodeDetails // Envoy node of the page details, see node
+
+	// captureRequest and captureResponse hold the lower-case names of the
+	// headers exposed to templates
+	captureRequest  map[string]bool
+	captureResponse map[string]bool
+
+	// correlationHeaders are the lower-case names of the correlation headers,
+	// in configured order
+	correlationHeaders []string
+}
+
+// NewHttpContext implements types.PluginContext.
+func (ctx *pluginContext) NewHttpContext(contextID uint32) types.HttpContext {
+	return &httpContext{plugin: ctx}
+}
+
+// OnPluginStart implements types.PluginContext.
+func (ctx *pluginContext) OnPluginStart(pluginConfigurationSize int) types.OnPluginStartStatus {
+	proxywasm.LogInfo("WASM Error Pages Plugin initialized (version: " + version + ")")
+	ctx.metrics = metrics.Define()
+
+	// Parse configuration, preferring the one passed by the host
+	configYAML := defaultConfig
+	if pluginConfigurationSize > 0 {
+		data, err := proxywasm.GetPluginConfiguration()
+		if err != nil {
+			proxywasm.LogCriticalf("Failed to read plugin configuration: %v", err)
+			return types.OnPluginStartStatusFailed
+		}
+		configYAML = data
+	}
+	cfg, err := config.ParsePluginConfiguration(configYAML)
+	if err != nil {
+		proxywasm.LogCriticalf("Failed to parse configuration: %v", err)
+		return types.OnPluginStartStatusFailed
+	}
+	ctx.config = cfg
+	ctx.configChecksum = status.Checksum(configYAML)
+	ctx.logger = logging.New(cfg.LogFormat)
+	ctx.logger.SetLevel(cfg.LogLevel)
+	ctx.sampler = logging.NewSampler(cfg.LogSampleRate)
+	ctx.captureRequest = headerSet(cfg.CaptureHeaders.Request)
+	ctx.captureResponse = headerSet(cfg.CaptureHeaders.Response)
+	for _, name := range cfg.CorrelationHeaders {
+		ctx.correlationHeaders = append(ctx.correlationHeaders, strings.ToLower(name))
+	}
+	if ctx.tickPeriod = tickPeriod(cfg); ctx.tickPeriod > 0 {
+		if err := proxywasm.SetTickPeriodMilliSeconds(uint32(ctx.tickPeriod) * 1000); err != nil {
+			proxywasm.LogWarnf("failed to set tick period: %v", err)
+		}
+	}
+
+	ctx.privacyOptions = privacy.Options{
+		MaskClientIP:       cfg.Privacy.MaskClientIP,
+		HideForwardedChain: cfg.Privacy.HideForwardedChain,
+		RequestIDLength:    cfg.Privacy.RequestIDLength,
+	}
+
+	ctx.location, err = cfg.Location()
+	if err != nil {
+		proxywasm.LogCriticalf("Failed to load timezone: %v", err)
+		return types.OnPluginStartStatusFailed
+	}
+
+	ctx.catalog, err = l10n.Load()
+	if err != nil {
+		proxywasm.LogCriticalf("Failed to load translations: %v", err)
+		return types.OnPluginStartStatusFailed
+	}
+	for lang, o := range cfg.Localization.Overrides {
+		ctx.catalog.Override(lang, &l10n.Translation{Name: o.Name, Messages: o.Messages, Descriptions: o.Descriptions, Labels: o.Labels, TimestampLayout: o.TimestampLayout})
+	}
+	if lang := cfg.Localization.DefaultLanguage; !ctx.catalog.Supported(lang) {
+		proxywasm.LogCriticalf("Unsupported localization.default_language %q: available languages are %v", lang, ctx.catalog.Languages())
+		return types.OnPluginStartStatusFailed
+	}
+	if cfg.Localization.Enabled {
+		proxywasm.LogInfof("Localization enabled: languages=%v, default_language=%s", ctx.catalog.Languages(), cfg.Localization.DefaultLanguage)
+	}
+	if cfg.Localization.ClientScript {
+		ctx.l10nScript, err = ctx.catalog.ClientScript()
+		if err != nil {
+			proxywasm.LogCriticalf("Failed to build l10n script: %v", err)
+			return types.OnPluginStartStatusFailed
+		}
+		proxywasm.LogInfof("Client-side localization script enabled")
+	}
+
+	if err := ctx.loadThemes(); err != nil {
+		proxywasm.LogCriticalf("Failed to load themes: %v", err)
+		return types.OnPluginStartStatusFailed
+	}
+	if !cfg.UsesThemePool() {
+		cfg.Theme = ctx.themeNames[0]
+	}
+	if cfg.Theme == config.ThemeWeighted {
+		ctx.metrics.DefineVariants(ctx.themeNames)
+	}
+	if cfg.Beacon.Enabled {
+		proxywasm.LogInfof("Analytics beacon enabled: endpoint=%s, method=%s", cfg.Beacon.Endpoint, cfg.Beacon.Method)
+	}
+	if cfg.ErrorTracking.Enabled {
+		proxywasm.LogInfof("Error tracking enabled: environment=%s, release=%s", cfg.ErrorTracking.Environment, version)
+	}
+	if b := cfg.IncidentBanner; b.Enabled {
+		proxywasm.LogInfof("Incident banner enabled: cluster=%s, path=%s, interval=%ds", b.Cluster, b.Path, b.Interval)
+	}
+	if p := cfg.StatusProvider; p.Enabled {
+		ctx.statusProvider, _ = statuspage.New(p.Type)
+		proxywasm.LogInfof("Status provider enabled: type=%s, cluster=%s, interval=%ds", p.Type, p.Cluster, p.Interval)
+	}
+	if cfg.Localization.Switcher {
+		ctx.l10nBundles = make(map[int]string)
+		proxywasm.LogInfof("Language switcher enabled: languages=%v", ctx.catalog.Languages())
+	}
+
+	if cfg.CSP.Enabled {
+		ctx.cspPolicy = ctx.newCSPPolicy()
+		proxywasm.LogInfof("Content-Security-Policy enabled: %s", ctx.cspPolicy.Header(csp.NoncePlaceholder))
+	}
+
+	if cfg.SecurityHeaders.Enabled {
+		ctx.securityHeaders = cfg.SecurityHeaders.Headers()
+		proxywasm.LogInfof("Security headers enabled: %v", ctx.securityHeaders)
+	}
+
+	ctx.debugEndpoints = make(map[string]func(*httpContext) types.Action)
+	if cfg.Status.Enabled {
+		ctx.debugEndpoints[cfg.Status.Path] = (*httpContext).serveStatus
+	}
+	if cfg.ConfigDump.Enabled {
+		ctx.debugEndpoints[cfg.ConfigDump.Path] = (*httpContext).serveConfigDump
+	}
+	if cfg.Control.Enabled {
+		ctx.debugEndpoints[cfg.Control.Path] = (*httpContext).serveControl
+		ctx.control = control.NewStore(cfg.Control.Path)
+		ctx.syncControl()
+		proxywasm.LogInfof("Control endpoint enabled: path=%s", cfg.Control.Path)
+	}
+	if r := cfg.RecentErrors; r.Enabled {
+		ctx.debugEndpoints[r.Path] = (*httpContext).serveRecentErrors
+		ctx.recentErrors = recent.New(r.Path, r.Size)
+		proxywasm.LogInfof("Recent errors endpoint enabled: path=%s, size=%d", r.Path, r.Size)
+	}
+
+	if cfg.StaticPages() {
+		langs := []string{cfg.Localization.DefaultLanguage}
+		if cfg.Localization.Enabled {
+			langs = ctx.catalog.Languages()
+		}
+		codes := errorpages.StatusCodes(cfg.CustomStatusCodes())
+		for name, handler := range ctx.handlers {
+			if err := handler.Prerender(codes, langs, ctx.localize); err != nil {
+				proxywasm.LogCriticalf("Failed to pre-render error pages of theme '%s': %v", name, err)
+				return types.OnPluginStartStatusFailed
+			}
+		}
+		proxywasm.LogInfof("Pre-rendered error pages for %d status codes in %d languages and %d themes", len(codes), len(langs), len(ctx.handlers))
+	} else if rc := cfg.RenderCache; rc.Enabled {
+		ctx.renderCache = rendercache.New(time.Duration(rc.TTL)*time.Second, rc.MaxEntries)
+		// Skeletons are shared between workers, so they must embed the same
+		// sentinel token
+		if err := errorpages.SetSkeletonToken(ctx.renderCache.Token(errorpages.SkeletonToken())); err != nil {
+			proxywasm.LogWarnf("Failed to use the shared skeleton token: %v", err)
+		}
+		proxywasm.LogInfof("Shared render cache enabled: ttl=%ds, max_entries=%d", rc.TTL, rc.MaxEntries)
+	}
+
+	if cfg.Theme == config.ThemeWeighted {
+		proxywasm.LogInfof("Error page templates loaded: theme=%s, variants=%v, weights=%v, cookie=%s, show_details=%v", cfg.Theme, ctx.themeNames, ctx.themeWeights, cfg.ThemeCookie, cfg.ShowDetails)
+	} else if cfg.UsesThemePool() {
+		proxywasm.LogInfof("Error page templates loaded: theme=%s, pool=%v, show_details=%v", cfg.Theme, ctx.themeNames, cfg.ShowDetails)
+	} else {
+		proxywasm.LogInfof("Error page template loaded: theme=%s, show_details=%v", cfg.Theme, cfg.ShowDetails)
+	}
+	if ctx.theme4xx != "" || ctx.theme5xx != "" {
+		proxywasm.LogInfof("Status class themes: theme_4xx=%s, theme_5xx=%s", ctx.theme4xx, ctx.theme5xx)
+	}
+	return types.OnPluginStartStatusOK
+}
+
+// OnTick implements types.PluginContext.
+func (ctx *pluginContext) OnTick() {
+	ctx.ticks++
+	elapsed := ctx.ticks * ctx.tickPeriod
+	if interval := ctx.config.LogSummaryInterval; interval > 0 && elapsed%interval == 0 {
+		if seen, suppressed := ctx.sampler.Flush(); seen > 0 {
+			ctx.logger.Summary(interval, seen, suppressed)
+		}
+	}
+	// Status APIs are also fetched on the first tick, so pages don't wait a
+	// full interval after start for the banner and the components
+	first := ctx.ticks == 1
+	if b := ctx.config.IncidentBanner; b.Enabled && (first || elapsed%b.Interval == 0) {
+		ctx.fetchIncident()
+	}
+	if p := ctx.config.StatusProvider; p.Enabled && (first || elapsed%p.Interval == 0) {
+		ctx.fetchComponents()
+	}
+}
+
+// tickPeriod returns the tick period in seconds that serves every periodic
+// task, the greatest common divisor of their intervals, or 0 if there is none
+func tickPeriod(cfg *config.Config) int {
+	intervals := []int{cfg.LogSummaryInterval}
+	if cfg.IncidentBanner.Enabled {
+		intervals = append(intervals, cfg.IncidentBanner.Interval)
+	}
+	if cfg.StatusProvider.Enabled {
+		intervals = append(intervals, cfg.StatusProvider.Interval)
+	}
+	period := 0
+	for _, interval := range intervals {
+		for b := interval; b > 0; {
+			period, b = b, period%b
+		}
+	}
+	return period
+}
+
+// httpContext implements types.HttpContext.
+type httpContext struct {
+	types.DefaultHttpContext
+	plugin *pluginContext // the plugin instance the request belongs to
+
+	shouldReplaceBody bool
+	bodyReplaced      bool
+	localReply        bool
+	otherDirection    bool // the listener's traffic direction isn't handled
+	statusCode        string
+	theme             string        // picked when the response is intercepted
+	route             *config.Route // rule of the matched route, if any
+	outcome           string        // set once known, see OnHttpStreamDone
+	// Request data for template rendering
+	host         string
+	originalURI  string
+	rewrittenURI string // :path after a rewrite, when it differs from originalURI
+	attempts     int    // upstream attempts, when Envoy retried
+	codeDetails  string // Envoy's response code details
+	requestSize  int64  // request body bytes
+	responseSize int64  // upstream response body bytes, as announced or buffered
+	// Captured headers, see config.CaptureHeaders
+	requestHeaders  map[string]string
+	responseHeaders map[string]string
+	correlation     [][2]string // correlation headers present on the request
+	forwardedFor    string
+	requestID       string
+	trace           tracing.IDs
+	lang            string
+	langHinted      bool // lang was set by the locale header
+	nonce           string
+	variant         string // theme variant with theme: weighted
+	newVariant      bool   // variant was assigned by this request, see variantCookie
+	secure          bool   // the client used HTTPS, see variantCookie
+}
+
+// OnHttpRequestHeaders implements types.HttpContext.
+func (ctx *httpContext) OnHttpRequestHeaders(numHeaders int, endOfStream bool) types.Action {
+	if !ctx.plugin.directionMatches() {
+		// Leave the stream alone, including debug paths and maintenance mode
+		ctx.otherDirection = true
+		ctx.outcome = logging.OutcomePassedThrough
+		return types.ActionContinue
+	}
+
+	// Capture request data for error page rendering
+	if host, err := proxywasm.GetHttpRequestHeader(":authority"); err == nil {
+		ctx.host = host
+	} else if host, err := proxywasm.GetHttpRequestHeader("host"); err == nil {
+		ctx.host = host
+	}
+
+	if path, err := proxywasm.GetHttpRequestHeader(":path"); err == nil {
+		ctx.originalURI = path
+	}
+
+	if xff, err := proxywasm.GetHttpRequestHeader("x-forwarded-for"); err == nil {
+		ctx.forwardedFor = xff
+	}
+
+	if reqID, err := proxywasm.GetHttpRequestHeader("x-request-id"); err == nil {
+		ctx.requestID = reqID
+	}
+
+	if len(ctx.plugin.captureRequest) > 0 {
+		if headers, err := proxywasm.GetHttpRequestHeaders(); err == nil {
+			ctx.requestHeaders = captureHeaders(headers, ctx.plugin.captureRequest, ctx.plugin.privacyOptions)
+		}
+	}
+	for _, name := range ctx.plugin.correlationHeaders {
+		if value, err := proxywasm.GetHttpRequestHeader(name); err == nil && value != "" {
+			ctx.correlation = append(ctx.correlation, [2]string{name, value})
+		}
+	}
+
+	ctx.lang = ctx.plugin.config.Localization.DefaultLanguage
+	if ctx.plugin.config.Localization.Enabled {
+		acceptLanguage, _ := proxywasm.GetHttpRequestHeader("accept-language")
+		ctx.lang = ctx.plugin.catalog.Negotiate(acceptLanguage, ctx.lang)
+		if name := ctx.plugin.config.Localization.LocaleHeader; name != "" {
+			hint, _ := proxywasm.GetHttpRequestHeader(name)
+			ctx.applyLocaleHint(hint)
+		}
+	}
+
+	ctx.trace = tracing.FromHeaders(func(name string) string {
+		value, _ := proxywasm.GetHttpRequestHeader(name)
+		return value
+	})
+	ctx.route = ctx.plugin.matchRoute()
+	if ctx.plugin.config.Theme == config.ThemeWeighted {
+		ctx.assignVariant()
+		ctx.secure = isHTTPS()
+	}
+
+	if ctx.plugin.control != nil {
+		ctx.plugin.syncControl()
+	}
+
+	if serve, ok := ctx.plugin.debugEndpoints[requestPath(ctx.originalURI)]; ok {
+		return ctx.serveDebug(serve)
+	}
+	if p := ctx.plugin.config.Preview; p.Enabled {
+		if code, ok := strings.CutPrefix(requestPath(ctx.originalURI), p.Path+"/"); ok {
+			return ctx.serveDebug(func(ctx *httpContext) types.Action { return ctx.servePreview(code) })
+		}
+	}
+	if ctx.plugin.controlState.Maintenance {
+		return ctx.serveMaintenance()
+	}
+
+	return types.ActionContinue
+}
+
+// applyLocaleHint switches the page language to the one named by the locale
+// header, set by applications that know the user's preference better than
+// the browser. Hints use Accept-Language syntax; unsupported languages are
+// ignored.
+func (ctx *httpContext) applyLocaleHint(hint string) {
+	if lang := ctx.plugin.catalog.Negotiate(strings.ReplaceAll(hint, "_", "-"), ""); lang != "" {
+		ctx.lang = lang
+		ctx.langHinted = true
+	}
+}
+
+// serveDebug answers a debug endpoint directly from the plugin, after
+// checking the shared debug token
+func (ctx *httpContext) serveDebug(serve func(*httpContext) types.Action) types.Action {
+	ctx.localReply = true
+
+	token, _ := proxywasm.GetHttpRequestHeader(ctx.plugin.config.Debug.TokenHeader)
+	if !status.Authorized(ctx.plugin.config.Debug.Token, token) {
+		proxywasm.LogWarnf("rejected unauthorized debug request for %s", ctx.originalURI)
+		if err := proxywasm.SendHttpResponse(401, [][2]string{{"content-type", "text/plain"}}, []byte("unauthorized\n"), -1); err != nil {
+			proxywasm.LogErrorf("failed to send debug response: %v", err)
+		}
+		return types.ActionPause
+	}
+	return serve(ctx)
+}
+
+// serveStatus answers the status endpoint
+func (ctx *httpContext) serveStatus() types.Action {
+	doc := &status.Document{
+		Version:        version,
+		Theme:          ctx.plugin.config.Theme,
+		ConfigChecksum: ctx.plugin.configChecksum,
+		Counters:       ctx.plugin.metrics.Counters(),
+	}
+	headers := [][2]string{
+		{"content-type", "application/json"},
+		{"cache-control", "no-store"},
+	}
+	if err := proxywasm.SendHttpResponse(200, headers, doc.JSON(), -1); err != nil {
+		proxywasm.LogErrorf("failed to send status response: %v", err)
+	}
+	return types.ActionPause
+}
+
+// serveConfigDump answers the effective configuration endpoint
+func (ctx *httpContext) serveConfigDump() types.Action {
+	doc := &status.ConfigDump{
+		Version:        version,
+		ConfigChecksum: ctx.plugin.configChecksum,
+		Themes:         slices.Sorted(maps.Keys(ctx.plugin.handlers)),
+		Runtime:        ctx.plugin.controlState,
+	}
+	effective, err := ctx.plugin.config.Effective()
+	if err == nil && ctx.route != nil {
+		doc.Route, err = config.Plain(ctx.route)
+	}
+	if err != nil {
+		proxywasm.LogErrorf("failed to encode effective configuration: %v", err)
+		if err := proxywasm.SendHttpResponse(500, [][2]string{{"content-type", "text/plain"}}, []byte("internal error\n"), -1); err != nil {
+			proxywasm.LogErrorf("failed to send config dump response: %v", err)
+		}
+		return types.ActionPause
+	}
+	doc.Config = effective
+	headers := [][2]string{
+		{"content-type", "application/json"},
+		{"cache-control", "no-store"},
+	}
+	if err := proxywasm.SendHttpResponse(200, headers, doc.JSON(), -1); err != nil {
+		proxywasm.LogErrorf("failed to send config dump response: %v", err)
+	}
+	return types.ActionPause
+}
+
+// serveRecentErrors answers the recent errors endpoint
+func (ctx *httpContext) serveRecentErrors() types.Action {
+	events, err := ctx.plugin.recentErrors.Events()
+	if err != nil {
+		proxywasm.LogErrorf("failed to read recent errors: %v", err)
+		if err := proxywasm.SendHttpResponse(500, [][2]string{{"content-type", "text/plain"}}, []byte("internal error\n"), -1); err != nil {
+			proxywasm.LogErrorf("failed to send recent errors response: %v", err)
+		}
+		return types.ActionPause
+	}
+	doc := &recent.Document{Size: ctx.plugin.config.RecentErrors.Size, Events: events}
+	headers := [][2]string{
+		{"content-type", "application/json"},
+		{"cache-control", "no-store"},
+	}
+	if err := proxywasm.SendHttpResponse(200, headers, doc.JSON(), -1); err != nil {
+		proxywasm.LogErrorf("failed to send recent errors response: %v", err)
+	}
+	return types.ActionPause
+}
+
+// recordError adds the intercepted error to the recent errors, without the
+// query string of its path
+func (ctx *httpContext) recordError(code int) {
+	err := ctx.plugin.recentErrors.Add(recent.Event{
+		Time:      time.Now().UTC().Truncate(time.Millisecond),
+		Code:      code,
+		Host:      ctx.host,
+		Path:      requestPath(ctx.originalURI),
+		RequestID: ctx.requestID,
+	})
+	if err != nil {
+		proxywasm.LogDebugf("failed to record recent error: %v", err)
+	}
+}
+
+// OnHttpResponseHeaders implements types.HttpContext.
+func (ctx *httpContext) OnHttpResponseHeaders(numHeaders int, endOfStream bool) types.Action {
+	if ctx.localReply || ctx.otherDirection {
+		return types.ActionContinue
+	}
+
+	status, err := proxywasm.GetHttpResponseHeader(":status")
+	if err != nil {
+		proxywasm.LogWarnf("failed to get status code: %v", err)
+		return types.ActionContinue
+	}
+
+	ctx.statusCode = status
+	ctx.plugin.logger.Debugf(ctx.event(logging.ActionResponse, nil), "response status code: %s", status)
+
+	// Route rules may leave the error responses of a route alone
+	if ctx.route != nil && ctx.route.Skip {
+		return types.ActionContinue
+	}
+
+	// Check if this is a 4xx or 5xx error
+	if errorpages.IsErrorStatus(status) {
+		ctx.shouldReplaceBody = true
+		ctx.resolveOriginalURI()
+		ctx.attempts = upstreamAttempts()
+		ctx.requestSize, _ = intProperty("request", "size")
+		if length, err := proxywasm.GetHttpResponseHeader("content-length"); err == nil {
+			ctx.responseSize, _ = strconv.ParseInt(length, 10, 64)
+		}
+		if details, err := proxywasm.GetProperty([]string{"response", "code_details"}); err == nil {
+			ctx.codeDetails = string(details)
+		}
+		if len(ctx.plugin.captureResponse) > 0 {
+			if headers, err := proxywasm.GetHttpResponseHeaders(); err == nil {
+				ctx.responseHeaders = captureHeaders(headers, ctx.plugin.captureResponse, ctx.plugin.privacyOptions)
+			}
+		}
+		if name := ctx.plugin.config.Localization.LocaleHeader; name != "" && ctx.plugin.config.Localization.Enabled {
+			// The upstream's hint wins over the one of the request
+			if hint, err := proxywasm.GetHttpResponseHeader(name); err == nil {
+				ctx.applyLocaleHint(hint)
+				proxywasm.RemoveHttpResponseHeader(name)
+			}
+		}
+		ctx.theme = ctx.plugin.pickTheme(status, ctx.route, ctx.variant)
+		ctx.plugin.metrics.Intercepted.Increment(1)
+		if counter, ok := ctx.plugin.metrics.Variants[ctx.theme]; ok && ctx.theme == ctx.variant {
+			counter.Increment(1)
+		}
+		if ctx.plugin.sampler.Sample() {
+			if ctx.trace.TraceID != "" {
+				ctx.plugin.logger.Infof(ctx.event(logging.ActionIntercept, nil), "intercepting error response: %s trace_id=%s", status, ctx.trace.TraceID)
+			} else {
+				ctx.plugin.logger.Infof(ctx.event(logging.ActionIntercept, nil), "intercepting error response: %s", status)
+			}
+		}
+
+		// Remove headers that could conflict with our custom error page
+		proxywasm.RemoveHttpResponseHeader("content-length")
+		proxywasm.RemoveHttpResponseHeader("content-encoding")
+		proxywasm.RemoveHttpResponseHeader("content-type")
+
+		// Set content type for our HTML error page
+		proxywasm.AddHttpResponseHeader("content-type", "text/html; charset=utf-8")
+
+		if ctx.plugin.cspPolicy != nil {
+			ctx.setCSP()
+		}
+		if ctx.plugin.config.StripProxyHeaders {
+			stripProxyHeaders()
+		}
+		for _, h := range ctx.plugin.securityHeaders {
+			if err := proxywasm.ReplaceHttpResponseHeader(h[0], h[1]); err != nil {
+				proxywasm.LogWarnf("failed to set %s header: %v", h[0], err)
+			}
+		}
+		if ctx.plugin.config.NoIndex {
+			if err := proxywasm.ReplaceHttpResponseHeader("x-robots-tag", "noindex"); err != nil {
+				proxywasm.LogWarnf("failed to set x-robots-tag header: %v", err)
+			}
+		}
+		if ctx.plugin.config.Localization.Enabled {
+			// The page language is negotiated, so caches must not serve a
+			// page to a client preferring another language
+			addVary("accept-language")
+		}
+		if ctx.variant != "" && ctx.theme == ctx.variant {
+			// Shared caches must not hand one client's variant, or its
+			// cookie, to others
+			if err := proxywasm.ReplaceHttpResponseHeader("cache-control", "no-store"); err != nil {
+				proxywasm.LogWarnf("failed to set cache-control header: %v", err)
+			}
+		}
+		if cookie := ctx.variantCookie(); cookie != "" {
+			proxywasm.AddHttpResponseHeader("set-cookie", cookie)
+		}
+		ctx.echoCorrelation()
+		code, _ := strconv.Atoi(status)
+		if ctx.plugin.recentErrors != nil {
+			ctx.recordError(code)
+		}
+		if seconds := ctx.plugin.config.RetryAfterFor(code); seconds > 0 {
+			// Keep the upstream's own estimate
+			if _, err := proxywasm.GetHttpResponseHeader("retry-after"); err != nil {
+				proxywasm.AddHttpResponseHeader("retry-after", strconv.Itoa(seconds))
+			}
+		}
+	}
+
+	return types.ActionContinue
+}
+
+// addVary adds a request header to the vary header of the response, keeping
+// the ones the upstream listed
+func addVary(name string) {
+	vary, err := proxywasm.GetHttpResponseHeader("vary")
+	if err != nil || strings.TrimSpace(vary) == "" {
+		vary = name
+	} else {
+		for _, v := range strings.Split(vary, ",") {
+			if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, name) {
+				return
+			}
+		}
+		vary += ", " + name
+	}
+	if err := proxywasm.ReplaceHttpResponseHeader("vary", vary); err != nil {
+		proxywasm.LogWarnf("failed to set vary header: %v", err)
+	}
+}
+
+// echoCorrelation copies the correlation headers of the request to the
+// error response, unless the upstream set them itself, so they end up in
+// screenshots and HAR files attached to support tickets
+func (ctx *httpContext) echoCorrelation() {
+	for _, h := range ctx.correlation {
+		if _, err := proxywasm.GetHttpResponseHeader(h[0]); err == nil {
+			continue
+		}
+		if err := proxywasm.AddHttpResponseHeader(h[0], h[1]); err != nil {
+			proxywasm.LogWarnf("failed to echo %s header: %v", h[0], err)
+		}
+	}
+}
+
+// OnHttpResponseBody implements types.HttpContext.
+func (ctx *httpContext) OnHttpResponseBody(bodySize int, endOfStream bool) types.Action {
+	if !ctx.shouldReplaceBody {
+		return types.ActionContinue
+	}
+
+	if ctx.bodyReplaced {
+		// Discard mode: drop the remaining upstream chunks.
+		if err := proxywasm.ReplaceHttpResponseBody(nil); err != nil {
+			proxywasm.LogDebugf("failed to discard response body chunk: %v", err)
+		}
+		return types.ActionContinue
+	}
+
+	if !endOfStream && ctx.plugin.config.BodyMode != config.BodyModeDiscard {
+		// Wait until we see the entire body to replace.
+		return types.ActionPause
+	}
+	if endOfStream {
+		// The whole body is buffered
+		ctx.responseSize = int64(bodySize)
+	}
+	ctx.bodyReplaced = true
+
+	// Parse status code to int
+	statusCode := 0
+	for i := 0; i < len(ctx.statusCode); i++ {
+		if ctx.statusCode[i] >= '0' && ctx.statusCode[i] <= '9' {
+			statusCode = statusCode*10 + int(ctx.statusCode[i]-'0')
+		}
+	}
+
+	errorPage, err := ctx.renderPage(statusCode)
+	if err != nil {
+		ctx.outcome = logging.OutcomeRenderFailed
+		ctx.plugin.metrics.RenderFailures.Increment(1)
+		ctx.plugin.logger.Warn(ctx.event(logging.ActionRenderFailed, err))
+		// The headers already announce an HTML page
+		ctx.serveFallback(statusCode)
+		return types.ActionContinue
+	}
+
+	return ctx.replaceBody(errorPage, statusCode)
+}
+
+// replaceBody replaces the response body with the rendered error page
+func (ctx *httpContext) replaceBody(errorPage []byte, statusCode int) types.Action {
+	if err := proxywasm.ReplaceHttpResponseBody(errorPage); err != nil {
+		ctx.outcome = logging.OutcomeReplaceFailed
+		ctx.plugin.metrics.ReplaceFailures.Increment(1)
+		ctx.plugin.logger.Warn(ctx.event(logging.ActionReplaceFailed, err))
+		ctx.serveFallback(statusCode)
+		return types.ActionContinue
+	}
+	ctx.outcome = logging.OutcomeReplaced
+
+	ctx.plugin.logger.Debugf(ctx.event(logging.ActionReplace, nil), "replaced error page for status: %s", ctx.statusCode)
+	return types.ActionContinue
+}
+
+// serveFallback replaces the body with the built-in fallback page after the
+// themed page failed, so clients never get the upstream body under headers
+// rewritten for the error page
+func (ctx *httpContext) serveFallback(statusCode int) {
+	if err := proxywasm.ReplaceHttpResponseBody(ctx.plugin.fallbackPage(statusCode, ctx.lang)); err != nil {
+		proxywasm.LogErrorf("failed to serve fallback page: %v", err)
+		return
+	}
+	ctx.plugin.metrics.FallbackPages.Increment(1)
+}
+
+// fallbackPage returns the built-in fallback page of a status code in lang
+func (ctx *pluginContext) fallbackPage(statusCode int, lang string) []byte {
+	var message string
+	if t := ctx.catalog.Get(lang); t != nil {
+		message, _ = t.Message(statusCode)
+	}
+	return errorpages.FallbackPage(statusCode, message, lang)
+}
+
+// renderPage renders the error page of the status code for the request
+func (ctx *httpContext) renderPage(statusCode int) ([]byte, error) {
+	showDetails := ctx.plugin.config.ShowDetails
+	if ctx.route != nil && ctx.route.ShowDetails != nil {
+		showDetails = *ctx.route.ShowDetails
+	}
+
+	// Serve the pre-rendered page when pages only depend on the status code.
+	// They never show details, which route metadata may turn on, nor the
+	// banner of the control endpoint.
+	if !showDetails && ctx.plugin.banner().message == "" && !ctx.langHinted {
+		if page, ok := ctx.plugin.handlers[ctx.theme].CachedPage(statusCode, ctx.lang); ok {
+			return page, nil
+		}
+	}
+
+	// Build template data
+	templateData := &errorpages.TemplateData{
+		Code:         statusCode,
+		ShowDetails:  showDetails,
+		Host:         ctx.host,
+		OriginalURI:  ctx.originalURI,
+		RewrittenURI: ctx.rewrittenURI,
+		Attempts:     ctx.attempts,
+		ForwardedFor: ctx.plugin.privacyOptions.ForwardedFor(ctx.forwardedFor),
+		RequestID:    ctx.plugin.privacyOptions.RequestID(ctx.requestID),
+		TraceID:      ctx.trace.TraceID,
+		SpanID:       ctx.trace.SpanID,
+		Nonce:        ctx.nonce,
+
+		RequestHeaders:  ctx.requestHeaders,
+		ResponseHeaders: ctx.responseHeaders,
+		CorrelationIDs:  ctx.displayCorrelation(),
+	}
+	templateData.SupportReference = ctx.plugin.config.QRCode.Text(templateData.RequestID)
+	templateData.StatusURL = ctx.plugin.config.StatusLink.URLFor(statusCode, templateData.RequestID, ctx.host)
+	if showDetails {
+		templateData.CodeDetails = ctx.codeDetails
+		templateData.RequestSize = ctx.requestSize
+		templateData.ResponseSize = ctx.responseSize
+		templateData.TLSVersion = connectionProperty("tls_version")
+		templateData.PeerSubject = connectionProperty("subject_peer_certificate")
+		if templateData.PeerSAN = connectionProperty("uri_san_peer_certificate"); templateData.PeerSAN == "" {
+			templateData.PeerSAN = connectionProperty("dns_san_peer_certificate")
+		}
+		if ctx.plugin.config.Node.Enabled {
+			ctx.plugin.setNode(templateData)
+		}
+	}
+	ctx.plugin.localize(templateData, ctx.lang)
+	if support := ctx.plugin.config.Support; support.Email != "" || support.URL != "" {
+		// Prefill the links with the time the page shows
+		now := time.Now()
+		templateData.NowUnix = now.Unix()
+		templateData.SupportMailto = support.MailtoURL(statusCode, cmp.Or(templateData.Message, errorpages.StatusMessage(statusCode)), templateData.RequestID, now)
+		templateData.SupportURL = support.TicketURL(statusCode, templateData.RequestID, now)
+	}
+	if ctx.langHinted {
+		// The client script would switch to the browser's language
+		templateData.L10nEnabled = false
+	}
+	return ctx.render(templateData)
+}
+
+// render renders the error page, reusing skeletons from the shared render
+// cache when it is enabled
+func (ctx *httpContext) render(data *errorpages.TemplateData) ([]byte, error) {
+	handler := ctx.plugin.handlers[ctx.theme]
+	if ctx.plugin.renderCache == nil {
+		if err := handler.RenderTo(&renderBuf, data); err != nil {
+			return nil, err
+		}
+		return renderBuf.Bytes(), nil
+	}
+
+	// Shared data is common to the plugin instances of the VM, so keys carry
+	// the configuration, incident banner and affected components the
+	// skeleton was rendered with
+	key := ctx.plugin.configChecksum + "|" + ctx.theme + "|" + ctx.plugin.banner().checksum + "|" + ctx.plugin.components.checksum + "|" + errorpages.SkeletonKey(data)
+	skeleton, ok := ctx.plugin.renderCache.Get(key)
+	if !ok {
+		var err error
+		skeleton, err = handler.RenderSkeleton(data)
+		if err != nil {
+			return nil, err
+		}
+		ctx.plugin.renderCache.Put(key, skeleton)
+	}
+	errorpages.FillSkeleton(&renderBuf, skeleton, data)
+	return renderBuf.Bytes(), nil
+}
+
+// OnHttpStreamDone implements types.HttpContext. It records the outcome of
+// every stream, including intercepted responses whose body never arrived.
+func (ctx *httpContext) OnHttpStreamDone() {
+	outcome := ctx.outcome
+	switch {
+	case outcome != "":
+	case ctx.localReply:
+		outcome = logging.OutcomeLocal
+	case ctx.shouldReplaceBody:
+		outcome = logging.OutcomeNotReplaced
+	case ctx.statusCode == "":
+		outcome = logging.OutcomeNoResponse
+	default:
+		outcome = logging.OutcomePassedThrough
+	}
+
+	m := ctx.plugin.metrics
+	switch outcome {
+	case logging.OutcomeReplaced:
+		m.Replaced.Increment(1)
+	case logging.OutcomePassedThrough:
+		m.PassedThrough.Increment(1)
+	case logging.OutcomeNotReplaced:
+		m.NotReplaced.Increment(1)
+	}
+
+	e := ctx.event(logging.ActionDone, nil)
+	e.Outcome = outcome
+	if outcome == logging.OutcomeNotReplaced {
+		ctx.plugin.logger.Infof(e, "stream done without replacing error response: %s", ctx.statusCode)
+		return
+	}
+	ctx.plugin.logger.Debugf(e, "stream done: %s", outcome)
+}
+
+// event builds a per-request log entry from the captured request data
+func (ctx *httpContext) event(action string, err error) *logging.Event {
+	code, _ := strconv.Atoi(ctx.statusCode)
+	theme := ctx.theme
+	if theme == "" {
+		theme = ctx.plugin.config.Theme
+	}
+	e := &logging.Event{
+		RequestID:   ctx.requestID,
+		Host:        ctx.host,
+		Path:        ctx.originalURI,
+		Code:        code,
+		Theme:       theme,
+		Action:      action,
+		TraceID:     ctx.trace.TraceID,
+		SpanID:      ctx.trace.SpanID,
+		Attempts:    ctx.attempts,
+		CodeDetails: ctx.codeDetails,
+	}
+	if len(ctx.correlation) > 0 {
+		e.Correlation = make(map[string]string, len(ctx.correlation))
+		for _, h := range ctx.correlation {
+			e.Correlation[h[0]] = h[1]
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// localize applies the translation for lang to the template data
+func (ctx *pluginContext) localize(data *errorpages.TemplateData, lang string) {
+	translation := ctx.catalog.Get(lang)
+	data.Localize(lang, translation)
+	if text, ok := ctx.config.StatusText(data.Code, errorpages.IsKnownStatus(data.Code)); ok {
+		data.SetStatusText(text.Message, text.Description, translation)
+	}
+	data.Location = ctx.location
+	data.DarkMode = ctx.config.DarkMode
+	data.RetryInterval = cmp.Or(ctx.config.Retry.Interval, -1)
+	data.RetryCountdown = ctx.config.Retry.Countdown
+	data.Variables = ctx.config.ThemeVariables
+	data.TitlePrefix = ctx.config.Metadata.TitlePrefix
+	data.Favicon = ctx.config.Metadata.Favicon
+	data.OGTitle = ctx.config.Metadata.OGTitle
+	data.OGImage = ctx.config.Metadata.OGImage
+	data.SupportEmail = ctx.config.Support.Email
+	data.SupportPhone = ctx.config.Support.Phone
+	data.SupportTel = ctx.config.Support.TelURL()
+	data.CustomScriptURL = ctx.config.CustomScript.URL
+	data.CustomScriptInline = ctx.config.CustomScript.Inline
+	banner := ctx.banner()
+	data.IncidentMessage = banner.message
+	data.IncidentURL = banner.url
+	data.IncidentResolvesAt = banner.resolvesAt
+	data.Components = ctx.components.affected
+	if ctx.l10nBundles != nil {
+		data.L10nBundle = ctx.switcherBundle(data.Code)
+	}
+	data.L10nEnabled = ctx.config.Localization.ClientScript
+	data.L10nScript = ctx.l10nScript
+}
+
+// setCSP generates the nonce of the page and replaces the upstream
+// Content-Security-Policy with the plugin's
+func (ctx *httpContext) setCSP() {
+	nonce, err := csp.Nonce()
+	if err != nil {
+		proxywasm.LogWarnf("failed to generate CSP nonce: %v", err)
+		return
+	}
+	ctx.nonce = nonce
+	if err := proxywasm.ReplaceHttpResponseHeader("content-security-policy", ctx.plugin.cspPolicy.Header(nonce)); err != nil {
+		proxywasm.LogWarnf("failed to set content-security-policy header: %v", err)
+	}
+}
+
+// stripProxyHeaders removes the response headers that advertise the proxy
+// stack
+func stripProxyHeaders() {
+	headers, err := proxywasm.GetHttpResponseHeaders()
+	if err != nil {
+		proxywasm.LogWarnf("failed to get response headers: %v", err)
+		return
+	}
+	for _, h := range headers {
+		name := strings.ToLower(h[0])
+		if name == "server" || name == "via" || strings.HasPrefix(name, "x-envoy-") {
+			if err := proxywasm.RemoveHttpResponseHeader(name); err != nil {
+				proxywasm.LogDebugf("failed to remove %s header: %v", name, err)
+			}
+		}
+	}
+}
+
+// newCSPPolicy allows the images of the templates and the endpoints of the
+// injected snippets on top of the strict default policy
+func (ctx *pluginContext) newCSPPolicy() *csp.Policy {
+	var imgSrc, connectSrc []string
+	for _, handler := range ctx.handlers {
+		for _, u := range handler.ImageURLs() {
+			imgSrc = append(imgSrc, csp.Origin(u))
+		}
+	}
+	if f := ctx.config.Metadata.Favicon; f != "" && !strings.HasPrefix(f, "data:") {
+		imgSrc = append(imgSrc, csp.Origin(f))
+	}
+	if l := ctx.config.ThemeVariables["logo"]; l != "" && !strings.HasPrefix(l, "data:") {
+		imgSrc = append(imgSrc, cspSource(l))
+	}
+	if b := ctx.config.Beacon; b.Enabled {
+		// sendBeacon falls back to an image request
+		imgSrc = append(imgSrc, cspSource(b.Endpoint))
+		if b.Method != "image" {
+			connectSrc = append(connectSrc, cspSource(b.Endpoint))
+		}
+	}
+	if et := ctx.config.ErrorTracking; et.Enabled {
+		connectSrc = append(connectSrc, csp.Origin(et.DSN))
+	}
+	var styleSrc []string
+	if ctx.config.CDN.Enabled {
+		styleSrc = append(styleSrc, csp.Origin(ctx.config.CDN.BaseURL))
+	}
+	return csp.New(ctx.config.CSP.Policy, imgSrc, connectSrc, styleSrc)
+}
+
+// cspSource returns the CSP source allowing a URL: its origin, or 'self' for
+// a path on the site itself
+func cspSource(u string) string {
+	if origin := csp.Origin(u); origin != "" {
+		return origin
+	}
+	if strings.HasPrefix(u, "/") {
+		return "'self'"
+	}
+	return ""
+}
+
+// switcherBundle returns the language switcher bundle of a status code,
+// building it on first use
+func (ctx *pluginContext) switcherBundle(code int) string {
+	if bundle, ok := ctx.l10nBundles[code]; ok {
+		return bundle
+	}
+	message, description := errorpages.StatusMessage(code), errorpages.StatusDescription(code)
+	if text, ok := ctx.config.StatusText(code, errorpages.IsKnownStatus(code)); ok {
+		message = cmp.Or(text.Message, message)
+		description = cmp.Or(text.Description, description)
+	}
+	bundle, err := ctx.catalog.Bundle(code, message, description)
+	if err != nil {
+		proxywasm.LogWarnf("failed to build language switcher bundle for %d: %v", code, err)
+		return "{}"
+	}
+	ctx.l10nBundles[code] = bundle
+	return bundle
+}
+
+// resolveOriginalURI replaces the captured :path with the path the user
+// requested when Envoy rewrote it. The router rewrites after this filter has
+// seen the request headers and keeps the original in x-envoy-original-path,
+// so both are read again once the response arrives.
+func (ctx *httpContext) resolveOriginalURI() {
+	original, err := proxywasm.GetHttpRequestHeader("x-envoy-original-path")
+	if err != nil || original == "" {
+		return
+	}
+	path, err := proxywasm.GetHttpRequestHeader(":path")
+	if err != nil {
+		path = ctx.originalURI
+	}
+	if path != original {
+		ctx.rewrittenURI = path
+	}
+	ctx.originalURI = original
+}
+
+// captureHeaders picks the named headers out of headers, joining the values
+// of repeated headers with commas, and applies the privacy options to them
+func captureHeaders(headers [][2]string, names map[string]bool, opts privacy.Options) map[string]string {
+	captured := make(map[string]string)
+	for _, h := range headers {
+		name := strings.ToLower(h[0])
+		if !names[name] {
+			continue
+		}
+		if value, ok := captured[name]; ok {
+			captured[name] = value + ", " + h[1]
+		} else {
+			captured[name] = h[1]
+		}
+	}
+	for name, value := range captured {
+		captured[name] = opts.Header(name, value)
+	}
+	return captured
+}
+
+// displayCorrelation returns the correlation headers of the request as shown
+// on the page, with the privacy options applied. Echoed and logged values
+// are left whole.
+func (ctx *httpContext) displayCorrelation() [][2]string {
+	if len(ctx.correlation) == 0 {
+		return nil
+	}
+	ids := make([][2]string, len(ctx.correlation))
+	for i, h := range ctx.correlation {
+		ids[i] = [2]string{h[0], ctx.plugin.privacyOptions.Header(h[0], h[1])}
+	}
+	return ids
+}
+
+// headerSet returns the set of the lower-cased names
+func headerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(name)] = true
+	}
+	return set
+}
+
+// upstreamAttempts returns how often the router tried the upstream, from the
+// x-envoy-attempt-count response header (include_attempt_count_in_response on
+// the virtual host). It is 0 when the header is missing or Envoy didn't
+// retry.
+func upstreamAttempts() int {
+	value, err := proxywasm.GetHttpResponseHeader("x-envoy-attempt-count")
+	if err != nil {
+		return 0
+	}
+	attempts, err := strconv.Atoi(value)
+	if err != nil || attempts < 2 {
+		return 0
+	}
+	return attempts
+}
+
+// intProperty reads an integer host property, which Envoy encodes as a
+// little-endian int64
+func intProperty(path ...string) (int64, bool) {
+	value, err := proxywasm.GetProperty(path)
+	if err != nil || len(value) != 8 {
+		return 0, false
+	}
+	return int64(binary.LittleEndian.Uint64(value)), true
+}
+
+// requestPath strips the query string from a :path value
+func requestPath(uri string) string {
+	path, _, _ := strings.Cut(uri, "?")
+	return path
+}
+
+// requestQuery returns the query string of a :path value
+func requestQuery(uri string) string {
+	_, query, _ := strings.Cut(uri, "?")
+	return query
+}
+
+// connectionProperty reads a property of the downstream connection, which is
+// empty for plaintext connections and clients without a certificate
+func connectionProperty(name string) string {
+	value, err := proxywasm.GetProperty([]string{"connection", name})
+	if err != nil {
+		return ""
+	}
+	return string(value)
+}
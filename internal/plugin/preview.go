@@ -0,0 +1,65 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"strconv"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// servePreview answers the preview endpoint with the page of a status code,
+// rendered like an intercepted response of the request. Previews are served
+// with status 200, so they don't show up in the error rates of the proxy;
+// x-error-pages-preview names the code.
+func (ctx *httpContext) servePreview(code string) types.Action {
+	if method, _ := proxywasm.GetHttpRequestHeader(":method"); method != "GET" && method != "HEAD" {
+		return ctx.sendPreviewError(405, "method not allowed\n")
+	}
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 400 || statusCode > 599 {
+		return ctx.sendPreviewError(404, "not a 4xx/5xx status code: "+code+"\n")
+	}
+
+	ctx.statusCode = code
+	ctx.theme = ctx.plugin.pickTheme(code, ctx.route, ctx.variant)
+	headers := ctx.pageHeaders(statusCode)
+	headers = append(headers, [2]string{"x-error-pages-preview", code})
+	page, err := ctx.renderPage(statusCode)
+	if err != nil {
+		proxywasm.LogWarnf("failed to render preview of %d with theme '%s': %v", statusCode, ctx.theme, err)
+		return ctx.sendPreviewError(500, "failed to render page: "+err.Error()+"\n")
+	}
+	if err := proxywasm.SendHttpResponse(200, headers, page, -1); err != nil {
+		proxywasm.LogErrorf("failed to send preview response: %v", err)
+	}
+	return types.ActionPause
+}
+
+// sendPreviewError sends a plain text error of the preview endpoint
+func (ctx *httpContext) sendPreviewError(code uint32, message string) types.Action {
+	headers := [][2]string{
+		{"content-type", "text/plain"},
+		{"cache-control", "no-store"},
+	}
+	if code == 405 {
+		headers = append(headers, [2]string{"allow", "GET, HEAD"})
+	}
+	if err := proxywasm.SendHttpResponse(code, headers, []byte(message), -1); err != nil {
+		proxywasm.LogErrorf("failed to send preview response: %v", err)
+	}
+	return types.ActionPause
+}
@@ -0,0 +1,134 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"strconv"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/config"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Envoy attributes naming the route a request matched, in the order route
+// rules are looked up
+var routeProperties = [][]string{
+	{"route_name"},
+	{"xds", "virtual_host_name"},
+}
+
+// routeMetadataPath is the Envoy attribute path of a key of the route rule in
+// the filter metadata of the matched route
+func routeMetadataPath(namespace, key string) []string {
+	return []string{"xds", "route_metadata", "filter_metadata", namespace, key}
+}
+
+// loadRoutes resolves the route rules of the configuration, loading the
+// themes they name
+func (ctx *pluginContext) loadRoutes() error {
+	ctx.routes = make(map[string]config.Route, len(ctx.config.Routes))
+	for _, name := range ctx.config.RouteNames() {
+		route := ctx.config.Routes[name]
+		if route.Theme != "" {
+			theme, err := ctx.loadTheme(route.Theme)
+			if err != nil {
+				return err
+			}
+			route.Theme = theme
+		}
+		ctx.routes[name] = route
+	}
+	return nil
+}
+
+// matchRoute returns the rule of the route the current request matched,
+// trying the route name before the virtual host name and applying the
+// route's metadata, or nil if none applies
+func (ctx *pluginContext) matchRoute() *config.Route {
+	var route *config.Route
+	if len(ctx.routes) > 0 {
+		for _, path := range routeProperties {
+			name, err := proxywasm.GetProperty(path)
+			if err != nil || len(name) == 0 {
+				continue
+			}
+			if r, ok := ctx.routes[string(name)]; ok {
+				route = &r
+				break
+			}
+		}
+	}
+	if ctx.config.RouteMetadata.Enabled {
+		route = ctx.applyRouteMetadata(route)
+	}
+	return route
+}
+
+// applyRouteMetadata overrides the fields of route with the keys set in the
+// route's filter metadata
+func (ctx *pluginContext) applyRouteMetadata(route *config.Route) *config.Route {
+	get := func(key string) ([]byte, bool) {
+		value, err := proxywasm.GetProperty(routeMetadataPath(ctx.config.RouteMetadata.Namespace, key))
+		return value, err == nil && len(value) > 0
+	}
+	var merged config.Route
+	if route != nil {
+		merged = *route
+	}
+	found := false
+	if value, ok := get("skip"); ok {
+		merged.Skip, found = metadataBool(value), true
+	}
+	if value, ok := get("show_details"); ok {
+		showDetails := metadataBool(value)
+		merged.ShowDetails, found = &showDetails, true
+	}
+	if value, ok := get("theme"); ok {
+		if theme := ctx.metadataTheme(string(value)); theme != "" {
+			merged.Theme, found = theme, true
+		}
+	}
+	if !found {
+		return route
+	}
+	return &merged
+}
+
+// metadataTheme returns the name a theme selected by route metadata was
+// loaded as, or "" if it was not loaded at start. Themes are never loaded on
+// the request path, where they would miss the Content-Security-Policy and
+// the external_assets check.
+func (ctx *pluginContext) metadataTheme(name string) string {
+	if theme, ok := ctx.metadataThemes[name]; ok {
+		return theme
+	}
+	if _, ok := ctx.handlers[name]; ok {
+		return name
+	}
+	proxywasm.LogWarnf("Ignoring theme '%s' of route metadata: not listed in route_metadata.themes", name)
+	// Warn once per theme
+	ctx.metadataThemes[name] = ""
+	return ""
+}
+
+// metadataBool decodes a boolean metadata value: Envoy passes booleans as a
+// single byte, string values such as "true" are parsed
+func metadataBool(value []byte) bool {
+	if len(value) == 1 && value[0] <= 1 {
+		return value[0] == 1
+	}
+	b, _ := strconv.ParseBool(string(value))
+	return b
+}
@@ -0,0 +1,228 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/config"
+	"github.com/ishioni/envoy-wasm-error-pages/internal/logging"
+	"github.com/ishioni/envoy-wasm-error-pages/themes"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// fallbackTheme replaces configured themes that don't exist
+const fallbackTheme = "app-down"
+
+// loadThemes parses every theme the configuration may render into
+// handlers. themeNames keeps the configured order of the base themes, with
+// their weights in themeWeights for theme: weighted,
+// theme4xx and theme5xx name the class themes, routes carry the themes of
+// route rules and metadataThemes the themes route metadata may select;
+// unknown themes are replaced by the fallback theme.
+func (ctx *pluginContext) loadThemes() error {
+	ctx.handlers = make(map[string]*errorpages.Handler)
+	ctx.metadataThemes = make(map[string]string)
+	ctx.themeNames, ctx.themeWeights, ctx.theme4xx, ctx.theme5xx = nil, nil, "", ""
+	for _, configured := range ctx.config.BaseThemes() {
+		name, err := ctx.loadTheme(configured)
+		if err != nil {
+			return err
+		}
+		ctx.themeNames = append(ctx.themeNames, name)
+		if ctx.config.Theme == config.ThemeWeighted {
+			ctx.themeWeights = append(ctx.themeWeights, ctx.config.ThemeWeights[configured])
+		}
+	}
+	var err error
+	if ctx.config.Theme4xx != "" {
+		if ctx.theme4xx, err = ctx.loadTheme(ctx.config.Theme4xx); err != nil {
+			return err
+		}
+	}
+	if ctx.config.Theme5xx != "" {
+		if ctx.theme5xx, err = ctx.loadTheme(ctx.config.Theme5xx); err != nil {
+			return err
+		}
+	}
+	if err := ctx.loadRoutes(); err != nil {
+		return err
+	}
+	if ctx.config.RouteMetadata.Enabled {
+		for _, configured := range ctx.config.RouteMetadata.Themes {
+			if ctx.metadataThemes[configured], err = ctx.loadTheme(configured); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadTheme parses a theme into a handler with the configured snippets and
+// returns the name it was loaded as
+func (ctx *pluginContext) loadTheme(name string) (string, error) {
+	if _, ok := ctx.handlers[name]; ok {
+		return name, nil
+	}
+	templateBytes, err := themes.Get(name)
+	if err != nil {
+		ctx.metrics.ThemeFallbacks.Increment(1)
+		ctx.logger.Warn(&logging.Event{Theme: name, Action: logging.ActionThemeFallback, Error: err.Error()})
+		proxywasm.LogWarnf("Theme '%s' not found, falling back to '%s'", name, fallbackTheme)
+		if _, ok := ctx.handlers[fallbackTheme]; ok {
+			return fallbackTheme, nil
+		}
+		name = fallbackTheme
+		templateBytes, err = themes.Get(name)
+		if err != nil {
+			return "", fmt.Errorf("failed to load fallback template: %w", err)
+		}
+	}
+	theme, err := themes.Info(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest of theme '%s': %w", name, err)
+	}
+	if ctx.config.Localization.Enabled && !theme.L10n {
+		proxywasm.LogWarnf("Theme '%s' has no translatable labels, only messages and descriptions will be localized", name)
+	}
+	for variable := range ctx.config.ThemeVariables {
+		if _, ok := theme.Variables[variable]; !ok {
+			proxywasm.LogWarnf("Theme '%s' does not declare variable '%s', its value may be ignored", name, variable)
+		}
+	}
+
+	switch refs := errorpages.ExternalAssets(string(templateBytes)); {
+	case len(refs) == 0:
+	case ctx.config.ExternalAssets == config.ExternalAssetsReject:
+		return "", fmt.Errorf("theme '%s' references external assets: %v", name, refs)
+	case ctx.config.ExternalAssets == config.ExternalAssetsStrip:
+		templateBytes = []byte(errorpages.StripExternalAssets(string(templateBytes)))
+		proxywasm.LogInfof("Stripped %d external asset references from theme '%s'", len(refs), name)
+	}
+
+	if ctx.config.NoIndex {
+		template, ok := errorpages.NoIndex(string(templateBytes))
+		if !ok {
+			proxywasm.LogWarnf("Theme '%s' has no <head> for the robots meta tag, relying on the X-Robots-Tag header", name)
+		}
+		templateBytes = []byte(template)
+	}
+
+	handler, err := errorpages.NewWithTemplate(templateBytes, version)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template of theme '%s': %w", name, err)
+	}
+	handler.SetVariableDefaults(theme.VariableDefaults())
+	if ctx.config.CDN.Enabled {
+		stylesheets, err := handler.UseStylesheets(ctx.config.CDN.BaseURL, func(data *errorpages.TemplateData) {
+			ctx.localize(data, ctx.config.Localization.DefaultLanguage)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to move styles of theme '%s' to stylesheets: %w", name, err)
+		}
+		proxywasm.LogInfof("Theme '%s' links %d stylesheets from %s", name, len(stylesheets), ctx.config.CDN.BaseURL)
+	}
+	if ctx.config.StatusLink.URL != "" && !slices.Contains(handler.Placeholders(), "status_url") {
+		if err := handler.AddSnippet(errorpages.StatusLinkSnippet()); err != nil {
+			return "", fmt.Errorf("failed to add status link to theme '%s': %w", name, err)
+		}
+	}
+	if ctx.config.Support.Enabled() && !slices.ContainsFunc(handler.Placeholders(), isSupportPlaceholder) {
+		if err := handler.AddSnippet(errorpages.SupportSnippet()); err != nil {
+			return "", fmt.Errorf("failed to add support contact to theme '%s': %w", name, err)
+		}
+	}
+	if ctx.config.CustomScript.Enabled() && !slices.Contains(handler.Placeholders(), "custom_script") {
+		if err := handler.AddSnippet(errorpages.CustomScriptSnippet()); err != nil {
+			return "", fmt.Errorf("failed to add custom script to theme '%s': %w", name, err)
+		}
+	}
+	if b := ctx.config.Beacon; b.Enabled {
+		if err := handler.AddSnippet(errorpages.BeaconSnippet(b.Endpoint, b.Method, name)); err != nil {
+			return "", fmt.Errorf("failed to add analytics beacon to theme '%s': %w", name, err)
+		}
+	}
+	if et := ctx.config.ErrorTracking; et.Enabled {
+		if err := handler.AddSnippet(errorpages.ErrorTrackingSnippet(et.ScriptURL, et.DSN, et.Environment, version)); err != nil {
+			return "", fmt.Errorf("failed to add error tracking snippet to theme '%s': %w", name, err)
+		}
+	}
+	if ctx.config.IncidentBanner.Enabled || ctx.config.Control.Enabled || ctx.config.StatusProvider.Enabled {
+		if err := handler.AddSnippet(errorpages.IncidentBannerSnippet()); err != nil {
+			return "", fmt.Errorf("failed to add incident banner to theme '%s': %w", name, err)
+		}
+	}
+	if ctx.config.QRCode.Enabled {
+		if err := handler.AddSnippet(errorpages.QRCodeSnippet()); err != nil {
+			return "", fmt.Errorf("failed to add QR code to theme '%s': %w", name, err)
+		}
+	}
+	if ctx.config.CopyDetails.Enabled {
+		if err := handler.AddSnippet(errorpages.CopyDetailsSnippet()); err != nil {
+			return "", fmt.Errorf("failed to add copy button to theme '%s': %w", name, err)
+		}
+	}
+	if r := ctx.config.Retry; r.Countdown && r.Interval > 0 {
+		if err := handler.AddSnippet(errorpages.RetryCountdownSnippet(r.MaxAttempts)); err != nil {
+			return "", fmt.Errorf("failed to add retry countdown to theme '%s': %w", name, err)
+		}
+	}
+	if ctx.config.Localization.Switcher {
+		if err := handler.AddSnippet(errorpages.LanguageSwitcherSnippet()); err != nil {
+			return "", fmt.Errorf("failed to add language switcher to theme '%s': %w", name, err)
+		}
+	}
+	ctx.handlers[name] = handler
+	return name, nil
+}
+
+// isSupportPlaceholder reports whether a theme using the placeholder shows
+// the support contact itself
+func isSupportPlaceholder(name string) bool {
+	return strings.HasPrefix(name, "support_")
+}
+
+// pickTheme selects the theme of an error page: the theme of the route rule
+// or the class theme of the status if configured, else a random one of the
+// pool for theme: random, the one of the day for theme: rotate, the
+// request's variant for theme: weighted, or the only one
+func (ctx *pluginContext) pickTheme(status string, route *config.Route, variant string) string {
+	switch {
+	case route != nil && route.Theme != "":
+		return route.Theme
+	case ctx.theme4xx != "" && strings.HasPrefix(status, "4"):
+		return ctx.theme4xx
+	case ctx.theme5xx != "" && strings.HasPrefix(status, "5"):
+		return ctx.theme5xx
+	}
+	switch ctx.config.Theme {
+	case config.ThemeWeighted:
+		return variant
+	case config.ThemeRandom:
+		return ctx.themeNames[rand.IntN(len(ctx.themeNames))]
+	case config.ThemeRotate:
+		now := time.Now().In(ctx.location)
+		_, offset := now.Zone()
+		day := (now.Unix() + int64(offset)) / (24 * 60 * 60)
+		return ctx.themeNames[day%int64(len(ctx.themeNames))]
+	}
+	return ctx.themeNames[0]
+}
@@ -0,0 +1,93 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+)
+
+// variantMaxAge is the lifetime, in seconds, of the cookie keeping a client
+// on its theme variant
+const variantMaxAge = 30 * 24 * 60 * 60
+
+// assignVariant picks the theme variant of the request for theme: weighted.
+// A variant named by the theme cookie is kept; other clients are assigned
+// one at random, which the cookie keeps from then on. Every request of
+// Envoy gets a new request ID, so the cookie is the only stable key.
+func (ctx *httpContext) assignVariant() {
+	p := ctx.plugin
+	cookies, _ := proxywasm.GetHttpRequestHeader("cookie")
+	if value, ok := cookieValue(cookies, p.config.ThemeCookie); ok {
+		if slices.Contains(p.themeNames, value) {
+			ctx.variant = value
+			return
+		}
+	}
+	total := 0
+	for _, w := range p.themeWeights {
+		total += w
+	}
+	n := rand.IntN(total)
+	for i, w := range p.themeWeights {
+		if n < w {
+			ctx.variant = p.themeNames[i]
+			break
+		}
+		n -= w
+	}
+	ctx.newVariant = true
+}
+
+// variantCookie returns the set-cookie header value keeping the client on
+// its variant, or "" if the request was already assigned one or the page
+// shows another theme. The cookie is only meant for the plugin, so scripts
+// can't read it, and it is only sent over HTTPS when the request used it.
+func (ctx *httpContext) variantCookie() string {
+	if !ctx.newVariant || ctx.theme != ctx.variant {
+		return ""
+	}
+	cookie := ctx.plugin.config.ThemeCookie + "=" + ctx.variant + "; Path=/; Max-Age=" + strconv.Itoa(variantMaxAge) + "; SameSite=Lax; HttpOnly"
+	if ctx.secure {
+		cookie += "; Secure"
+	}
+	return cookie
+}
+
+// isHTTPS reports whether the client used HTTPS, directly or through a
+// proxy in front of Envoy
+func isHTTPS() bool {
+	for _, name := range []string{":scheme", "x-forwarded-proto"} {
+		if value, err := proxywasm.GetHttpRequestHeader(name); err == nil && strings.EqualFold(strings.TrimSpace(value), "https") {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieValue returns the value of the cookie name in a cookie header
+func cookieValue(header, name string) (string, bool) {
+	for _, pair := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && key == name {
+			return strings.Trim(value, `"`), true
+		}
+	}
+	return "", false
+}
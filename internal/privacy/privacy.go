@@ -0,0 +1,102 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package privacy
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// Options selects which request details are masked on rendered pages
+type Options struct {
+	// MaskClientIP zeroes the last octet of IPv4 addresses and the last 80
+	// bits of IPv6 addresses
+	MaskClientIP bool
+	// HideForwardedChain shows only the client address of X-Forwarded-For
+	HideForwardedChain bool
+	// RequestIDLength truncates request IDs to this many characters; 0
+	// keeps them whole
+	RequestIDLength int
+}
+
+// ForwardedFor returns the X-Forwarded-For value to display
+func (o Options) ForwardedFor(xff string) string {
+	if xff == "" {
+		return ""
+	}
+	hops := strings.Split(xff, ",")
+	if o.HideForwardedChain {
+		hops = hops[:1]
+	}
+	for i, hop := range hops {
+		hop = strings.TrimSpace(hop)
+		if o.MaskClientIP {
+			hop = MaskIP(hop)
+		}
+		hops[i] = hop
+	}
+	return strings.Join(hops, ", ")
+}
+
+// RequestID returns the request ID to display
+func (o Options) RequestID(id string) string {
+	if o.RequestIDLength <= 0 || len(id) <= o.RequestIDLength {
+		return id
+	}
+	return id[:o.RequestIDLength] + "…"
+}
+
+// Header returns the value of a captured header to display. X-Forwarded-For
+// and X-Request-ID are shown like the request details, and MaskClientIP
+// also covers the other headers carrying the client address.
+func (o Options) Header(name, value string) string {
+	switch strings.ToLower(name) {
+	case "x-forwarded-for":
+		return o.ForwardedFor(value)
+	case "x-request-id":
+		return o.RequestID(value)
+	case "x-real-ip", "x-envoy-external-address", "true-client-ip", "cf-connecting-ip":
+		if o.MaskClientIP {
+			return MaskIP(strings.TrimSpace(value))
+		}
+	}
+	return value
+}
+
+// MaskIP anonymizes an IP address, with or without a port. Values that are
+// not IP addresses are returned unchanged.
+func MaskIP(value string) string {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return mask(addr).String()
+	}
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return netip.AddrPortFrom(mask(addrPort.Addr()), addrPort.Port()).String()
+	}
+	return value
+}
+
+func mask(addr netip.Addr) netip.Addr {
+	bits := 24
+	if addr.Is6() && !addr.Is4In6() {
+		bits = 48
+	} else if addr.Is4In6() {
+		bits = 96 + 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr
+	}
+	return prefix.Addr()
+}
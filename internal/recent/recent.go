@@ -0,0 +1,189 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recent keeps the last intercepted errors in proxy-wasm shared data,
+// so the recent errors endpoint shows those of every worker thread of the
+// VM.
+package recent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const (
+	keyPrefix = "error_pages.recent."
+	// casRetries bounds how often claiming a slot is retried when another
+	// worker claimed one concurrently
+	casRetries = 3
+	// maxFieldLength bounds the bytes kept of the host and path of an event
+	maxFieldLength = 256
+)
+
+// Event is an intercepted error response
+type Event struct {
+	Time      time.Time `json:"timestamp"`
+	Code      int       `json:"code"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Buffer is a ring buffer of the last Size events. Each event has a shared
+// data slot of its own; the head key counts the events recorded so far and
+// is the only value workers contend for. Slots start with the sequence
+// number of their event, so slots of overwritten or unfinished events are
+// told apart.
+type Buffer struct {
+	prefix string
+	size   int
+}
+
+// New returns the buffer of the recent errors endpoint at path, keeping the
+// last size events. Plugin instances with the same endpoint path share their
+// events.
+func New(path string, size int) *Buffer {
+	return &Buffer{prefix: keyPrefix + path + ".", size: size}
+}
+
+func (b *Buffer) headKey() string { return b.prefix + "head" }
+
+func (b *Buffer) slotKey(seq uint64) string {
+	return b.prefix + strconv.FormatUint(seq%uint64(b.size), 10)
+}
+
+// Add records an event, overwriting the oldest one when the buffer is full
+func (b *Buffer) Add(e Event) error {
+	e.Host = truncate(e.Host)
+	e.Path = truncate(e.Path)
+	event, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	seq, err := b.claim()
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 8+len(event))
+	binary.BigEndian.PutUint64(value[:8], seq)
+	copy(value[8:], event)
+	return b.store(b.slotKey(seq), value)
+}
+
+// store overwrites the slot key. Workers only write the same slot
+// concurrently once the buffer wrapped around, so the CAS value of the slot
+// is read first rather than relying on hosts to treat 0 as unconditional.
+func (b *Buffer) store(key string, value []byte) error {
+	for attempt := 0; attempt < casRetries; attempt++ {
+		_, cas, err := proxywasm.GetSharedData(key)
+		if err != nil && !errors.Is(err, types.ErrorStatusNotFound) {
+			return err
+		}
+		err = proxywasm.SetSharedData(key, value, cas)
+		if errors.Is(err, types.ErrorStatusCasMismatch) {
+			continue
+		}
+		return err
+	}
+	return errors.New("recent errors changed concurrently")
+}
+
+// claim returns the sequence number of the next event, advancing the head
+func (b *Buffer) claim() (uint64, error) {
+	for attempt := 0; attempt < casRetries; attempt++ {
+		seq, cas, err := b.head()
+		if err != nil {
+			return 0, err
+		}
+		next := make([]byte, 8)
+		binary.BigEndian.PutUint64(next, seq+1)
+		err = proxywasm.SetSharedData(b.headKey(), next, cas)
+		if errors.Is(err, types.ErrorStatusCasMismatch) {
+			continue
+		}
+		return seq, err
+	}
+	return 0, errors.New("recent errors changed concurrently")
+}
+
+// head returns the number of events recorded so far and the CAS value of
+// the head key
+func (b *Buffer) head() (uint64, uint32, error) {
+	raw, cas, err := proxywasm.GetSharedData(b.headKey())
+	if errors.Is(err, types.ErrorStatusNotFound) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(raw) != 8 {
+		// Start over rather than failing every request
+		return 0, cas, nil
+	}
+	return binary.BigEndian.Uint64(raw), cas, nil
+}
+
+// Events returns the recorded events, newest first
+func (b *Buffer) Events() ([]Event, error) {
+	count, _, err := b.head()
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for seq := count; seq > 0 && count-seq < uint64(b.size); seq-- {
+		raw, _, err := proxywasm.GetSharedData(b.slotKey(seq - 1))
+		if err != nil || len(raw) < 8 || binary.BigEndian.Uint64(raw[:8]) != seq-1 {
+			// Not written yet, or overwritten by a newer event
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(raw[8:], &e); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// truncate cuts s to maxFieldLength bytes without splitting a character
+func truncate(s string) string {
+	if len(s) <= maxFieldLength {
+		return s
+	}
+	n := maxFieldLength
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Document is the JSON document served at the recent errors endpoint
+type Document struct {
+	// Size is the capacity of the buffer
+	Size   int     `json:"size"`
+	Events []Event `json:"events"`
+}
+
+// JSON encodes the document, indented for reading
+func (d *Document) JSON() []byte {
+	b, _ := json.MarshalIndent(d, "", "  ")
+	return append(b, '\n')
+}
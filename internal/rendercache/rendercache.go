@@ -0,0 +1,107 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rendercache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const (
+	keyPrefix = "error_pages.render_cache."
+	tokenKey  = keyPrefix + "token"
+)
+
+// Cache keeps rendered pages in proxy-wasm shared data, so all worker threads
+// of a VM reuse each other's renders. Shared data keys cannot be deleted, so
+// pages are stored in MaxEntries slots picked by a hash of their key; a page
+// replaces the one in its slot. Slots hold the full key, so pages whose keys
+// share a slot are told apart. Entries expire after the TTL.
+type Cache struct {
+	TTL        time.Duration
+	MaxEntries int
+}
+
+// New creates a cache with the given TTL and number of slots
+func New(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{TTL: ttl, MaxEntries: maxEntries}
+}
+
+// Token returns the skeleton token shared by the worker threads of the VM,
+// storing token when none is stored yet. Workers that start concurrently may
+// each keep their own token, which only costs them each other's cache hits.
+func (c *Cache) Token(token string) string {
+	stored, cas, err := proxywasm.GetSharedData(tokenKey)
+	if err == nil && len(stored) > 0 {
+		return string(stored)
+	}
+	if err := proxywasm.SetSharedData(tokenKey, []byte(token), cas); errors.Is(err, types.ErrorStatusCasMismatch) {
+		if stored, _, err := proxywasm.GetSharedData(tokenKey); err == nil && len(stored) > 0 {
+			return string(stored)
+		}
+	}
+	return token
+}
+
+func (c *Cache) slotKey(key string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return keyPrefix + strconv.FormatUint(h.Sum64()%uint64(c.MaxEntries), 10)
+}
+
+// Get returns the cached page for key if it is present and not expired
+func (c *Cache) Get(key string) ([]byte, bool) {
+	value, _, err := proxywasm.GetSharedData(c.slotKey(key))
+	if err != nil || len(value) < 12 {
+		return nil, false
+	}
+	expiry := int64(binary.BigEndian.Uint64(value[:8]))
+	keyLen := int(binary.BigEndian.Uint32(value[8:12]))
+	if len(value) < 12+keyLen || string(value[12:12+keyLen]) != key {
+		return nil, false
+	}
+	if time.Now().UnixNano() > expiry {
+		return nil, false
+	}
+	return value[12+keyLen:], true
+}
+
+// Put stores page under key, replacing the page in its slot. The CAS value
+// of the slot is read first rather than relying on hosts to treat 0 as
+// unconditional; when another worker wrote the slot in between, its page is
+// kept.
+func (c *Cache) Put(key string, page []byte) {
+	value := make([]byte, 12+len(key)+len(page))
+	binary.BigEndian.PutUint64(value[:8], uint64(time.Now().Add(c.TTL).UnixNano()))
+	binary.BigEndian.PutUint32(value[8:12], uint32(len(key)))
+	copy(value[12:], key)
+	copy(value[12+len(key):], page)
+
+	slot := c.slotKey(key)
+	_, cas, err := proxywasm.GetSharedData(slot)
+	if err != nil && !errors.Is(err, types.ErrorStatusNotFound) {
+		proxywasm.LogDebugf("failed to read render cache slot: %v", err)
+		return
+	}
+	if err := proxywasm.SetSharedData(slot, value, cas); err != nil && !errors.Is(err, types.ErrorStatusCasMismatch) {
+		proxywasm.LogDebugf("failed to store rendered page in shared data: %v", err)
+	}
+}
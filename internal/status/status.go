@@ -0,0 +1,79 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Document is the JSON status document served at the status endpoint
+type Document struct {
+	Version        string            `json:"version"`
+	Theme          string            `json:"theme"`
+	ConfigChecksum string            `json:"config_checksum"`
+	Counters       map[string]uint64 `json:"counters"`
+}
+
+// JSON encodes the document
+func (d *Document) JSON() []byte {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return []byte(`{}`)
+	}
+	return b
+}
+
+// ConfigDump is the JSON document served at the effective configuration
+// endpoint
+type ConfigDump struct {
+	Version        string `json:"version"`
+	ConfigChecksum string `json:"config_checksum"`
+	// Config is the configuration with defaults applied
+	Config map[string]any `json:"config"`
+	// Themes are the themes loaded at plugin start, after fallbacks
+	Themes []string `json:"themes"`
+	// Route is the rule applied to the endpoint request, after route
+	// metadata, so querying through a route shows its rule
+	Route any `json:"route"`
+	// Runtime holds the toggles set through the control endpoint
+	Runtime any `json:"runtime"`
+}
+
+// JSON encodes the document, indented for reading
+func (d *ConfigDump) JSON() []byte {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return []byte(`{}`)
+	}
+	return append(b, '\n')
+}
+
+// Checksum returns the hex-encoded SHA-256 of the raw configuration
+func Checksum(config []byte) string {
+	sum := sha256.Sum256(config)
+	return hex.EncodeToString(sum[:])
+}
+
+// Authorized reports whether the presented token matches the configured one,
+// comparing in constant time. An empty configured token never matches.
+func Authorized(want, got string) bool {
+	if want == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
+}
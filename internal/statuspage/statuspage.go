@@ -0,0 +1,225 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package statuspage reads the component statuses of status page APIs, so
+// error pages can tell which part of the service is affected.
+package statuspage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Status is the normalized status of a component
+type Status string
+
+const (
+	Operational   Status = "operational"
+	Degraded      Status = "degraded"
+	PartialOutage Status = "partial_outage"
+	MajorOutage   Status = "major_outage"
+	Maintenance   Status = "maintenance"
+)
+
+// Label returns the English label of the status, which translates through
+// the locale labels
+func (s Status) Label() string {
+	switch s {
+	case Degraded:
+		return "Degraded performance"
+	case PartialOutage:
+		return "Partial outage"
+	case MajorOutage:
+		return "Major outage"
+	case Maintenance:
+		return "Under maintenance"
+	}
+	return "Operational"
+}
+
+// Component is a component of the service and its status
+type Component struct {
+	Name   string
+	Status Status
+}
+
+// Provider types
+const (
+	TypeStatuspage = "statuspage"
+	TypeCachet     = "cachet"
+	TypeJSON       = "json"
+)
+
+// Provider reads the components of a status page API
+type Provider interface {
+	// DefaultPath is the API path listing the components
+	DefaultPath() string
+	// Parse reads the components from the response body. Components with an
+	// unknown status are left out and described in skipped.
+	Parse(body []byte) (components []Component, skipped []string, err error)
+}
+
+// New returns the provider of a type
+func New(typ string) (Provider, error) {
+	switch typ {
+	case TypeStatuspage:
+		return statuspageProvider{}, nil
+	case TypeCachet:
+		return cachetProvider{}, nil
+	case TypeJSON:
+		return jsonProvider{}, nil
+	}
+	return nil, fmt.Errorf("unknown status provider %q", typ)
+}
+
+// statuspageProvider reads the components API of Atlassian Statuspage
+// (statuspage.io)
+type statuspageProvider struct{}
+
+func (statuspageProvider) DefaultPath() string { return "/api/v2/components.json" }
+
+func (statuspageProvider) Parse(body []byte) ([]Component, []string, error) {
+	var doc struct {
+		Components []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+			Group  bool   `json:"group"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, nil, err
+	}
+	var components []Component
+	var skipped []string
+	for _, c := range doc.Components {
+		// Groups summarize the components listed after them
+		if c.Group {
+			continue
+		}
+		status, ok := map[string]Status{
+			"operational":          Operational,
+			"degraded_performance": Degraded,
+			"partial_outage":       PartialOutage,
+			"major_outage":         MajorOutage,
+			"under_maintenance":    Maintenance,
+		}[c.Status]
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("component %q has unknown status %q", c.Name, c.Status))
+			continue
+		}
+		components = append(components, Component{Name: c.Name, Status: status})
+	}
+	return components, skipped, nil
+}
+
+// cachetProvider reads the components API of Cachet
+type cachetProvider struct{}
+
+func (cachetProvider) DefaultPath() string { return "/api/v1/components?per_page=100" }
+
+func (cachetProvider) Parse(body []byte) ([]Component, []string, error) {
+	var doc struct {
+		Data []struct {
+			Name    string `json:"name"`
+			Status  int    `json:"status"`
+			Enabled *bool  `json:"enabled"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, nil, err
+	}
+	var components []Component
+	var skipped []string
+	for _, c := range doc.Data {
+		if c.Enabled != nil && !*c.Enabled {
+			continue
+		}
+		var status Status
+		switch c.Status {
+		case 1:
+			status = Operational
+		case 2:
+			status = Degraded
+		case 3:
+			status = PartialOutage
+		case 4:
+			status = MajorOutage
+		default:
+			skipped = append(skipped, fmt.Sprintf("component %q has unknown status %d", c.Name, c.Status))
+			continue
+		}
+		components = append(components, Component{Name: c.Name, Status: status})
+	}
+	return components, skipped, nil
+}
+
+// jsonProvider reads a document in the plugin's own format:
+//
+//	{"components": [{"name": "API", "status": "degraded"}]}
+//
+// with the statuses operational, degraded, partial_outage, major_outage and
+// maintenance
+type jsonProvider struct{}
+
+func (jsonProvider) DefaultPath() string { return "/components.json" }
+
+func (jsonProvider) Parse(body []byte) ([]Component, []string, error) {
+	var doc struct {
+		Components []struct {
+			Name   string `json:"name"`
+			Status Status `json:"status"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, nil, err
+	}
+	var components []Component
+	var skipped []string
+	for _, c := range doc.Components {
+		switch c.Status {
+		case Operational, Degraded, PartialOutage, MajorOutage, Maintenance:
+		default:
+			skipped = append(skipped, fmt.Sprintf("component %q has unknown status %q", c.Name, c.Status))
+			continue
+		}
+		components = append(components, Component{Name: c.Name, Status: c.Status})
+	}
+	return components, skipped, nil
+}
+
+// Affected returns the components that aren't operational, limited to the
+// named ones unless names is empty. Names are matched case-insensitively.
+func Affected(components []Component, names []string) []Component {
+	var affected []Component
+	for _, c := range components {
+		if c.Status == Operational {
+			continue
+		}
+		if len(names) > 0 && !containsFold(names, c.Name) {
+			continue
+		}
+		affected = append(affected, c)
+	}
+	return affected
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(strings.TrimSpace(n), strings.TrimSpace(name)) {
+			return true
+		}
+	}
+	return false
+}
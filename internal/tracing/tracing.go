@@ -0,0 +1,56 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"strings"
+)
+
+// IDs holds the distributed trace identifiers of a request
+type IDs struct {
+	TraceID string
+	SpanID  string
+}
+
+// FromHeaders extracts the active trace and span IDs from W3C Trace Context,
+// B3 (single and multi header) or Datadog propagation headers. get returns
+// the value of a request header, or "" when it is absent.
+func FromHeaders(get func(name string) string) IDs {
+	// W3C: version-traceid-spanid-flags
+	if v := get("traceparent"); v != "" {
+		parts := strings.Split(v, "-")
+		if len(parts) >= 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+			return IDs{TraceID: parts[1], SpanID: parts[2]}
+		}
+	}
+
+	// B3 single header: traceid-spanid[-sampled[-parentspanid]]
+	if v := get("b3"); v != "" {
+		parts := strings.Split(v, "-")
+		if len(parts) >= 2 {
+			return IDs{TraceID: parts[0], SpanID: parts[1]}
+		}
+	}
+
+	if traceID := get("x-b3-traceid"); traceID != "" {
+		return IDs{TraceID: traceID, SpanID: get("x-b3-spanid")}
+	}
+
+	if traceID := get("x-datadog-trace-id"); traceID != "" {
+		return IDs{TraceID: traceID, SpanID: get("x-datadog-parent-id")}
+	}
+
+	return IDs{}
+}
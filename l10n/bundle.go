@@ -0,0 +1,69 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package l10n
+
+import "encoding/json"
+
+// DefaultLanguageName is the name of the default language in the switcher
+const DefaultLanguageName = "English"
+
+// bundleEntry holds the strings the language switcher swaps for one language
+type bundleEntry struct {
+	Name        string            `json:"name"`
+	Dir         string            `json:"dir"`
+	Message     string            `json:"message"`
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// Bundle returns the JSON translation bundle of the language switcher for a
+// status code, keyed by language. message and description are the built-in
+// English strings of the code. json.Marshal escapes <, > and &, so the bundle
+// is safe to embed in a script tag.
+func (c *Catalog) Bundle(code int, message, description string) (string, error) {
+	bundle := make(map[string]bundleEntry, len(c.translations)+1)
+	for _, lang := range c.Languages() {
+		e := bundleEntry{
+			Name:        lang,
+			Dir:         Direction(lang),
+			Message:     message,
+			Description: description,
+			Labels:      map[string]string{},
+		}
+		if lang == DefaultLanguage {
+			e.Name = DefaultLanguageName
+		}
+		if t := c.translations[lang]; t != nil {
+			if t.Name != "" {
+				e.Name = t.Name
+			}
+			if v, ok := t.Message(code); ok {
+				e.Message = v
+			}
+			if v, ok := t.Description(code); ok {
+				e.Description = v
+			}
+			if t.Labels != nil {
+				e.Labels = t.Labels
+			}
+		}
+		bundle[lang] = e
+	}
+	raw, err := json.Marshal(bundle)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
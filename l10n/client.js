@@ -0,0 +1,27 @@
+(function () {
+  var labels = window.errorPagesL10n || {};
+  var rtl = window.errorPagesL10nRTL || [];
+  var prefs = navigator.languages || [navigator.language || ''];
+  var dict = null;
+  var lang = '';
+  for (var i = 0; i < prefs.length && !dict; i++) {
+    var tag = String(prefs[i]).toLowerCase();
+    if (tag.indexOf('en') === 0) {
+      return;
+    }
+    lang = labels[tag] ? tag : tag.split('-')[0];
+    dict = labels[lang] || null;
+  }
+  if (!dict) {
+    return;
+  }
+  var nodes = document.querySelectorAll('[data-l10n]');
+  for (var j = 0; j < nodes.length; j++) {
+    var text = nodes[j].textContent.trim();
+    if (Object.prototype.hasOwnProperty.call(dict, text)) {
+      nodes[j].textContent = dict[text];
+    }
+  }
+  document.documentElement.lang = lang;
+  document.documentElement.dir = rtl.indexOf(lang) >= 0 ? 'rtl' : 'ltr';
+})();
@@ -0,0 +1,224 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package l10n holds the translations of the error pages, which the
+// errorpages package applies with TemplateData.Localize.
+package l10n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language of the built-in messages and templates,
+// which needs no translation file
+const DefaultLanguage = "en"
+
+// rtlLanguages are the supported languages written right-to-left
+var rtlLanguages = map[string]bool{"ar": true, "he": true}
+
+// Direction returns the text direction of lang, "rtl" or "ltr"
+func Direction(lang string) string {
+	if rtlLanguages[lang] {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// Translation holds the localized strings of a single language
+type Translation struct {
+	// Name is the language's own name, shown in the language switcher
+	Name string `json:"name"`
+	// Messages and Descriptions are keyed by status code, with "4xx" and
+	// "5xx" as fallbacks for codes without a dedicated entry
+	Messages     map[string]string `json:"messages"`
+	Descriptions map[string]string `json:"descriptions"`
+	// Labels are keyed by their English text as it appears in templates
+	Labels map[string]string `json:"labels"`
+	// TimestampLayout is the Go time layout of displayed timestamps
+	TimestampLayout string `json:"timestamp_layout"`
+}
+
+// Message returns the localized message for a status code
+func (t *Translation) Message(code int) (string, bool) {
+	return lookup(t.Messages, code)
+}
+
+// Description returns the localized description for a status code
+func (t *Translation) Description(code int) (string, bool) {
+	return lookup(t.Descriptions, code)
+}
+
+// Translates reports whether the message and description of a status code
+// have a translation of their own, rather than the 4xx/5xx fallback
+func (t *Translation) Translates(code int) (message, description bool) {
+	if t == nil {
+		return false, false
+	}
+	key := strconv.Itoa(code)
+	_, message = t.Messages[key]
+	_, description = t.Descriptions[key]
+	return message, description
+}
+
+func lookup(table map[string]string, code int) (string, bool) {
+	if v, ok := table[strconv.Itoa(code)]; ok {
+		return v, true
+	}
+	class := "5xx"
+	if code >= 400 && code < 500 {
+		class = "4xx"
+	}
+	v, ok := table[class]
+	return v, ok
+}
+
+// Catalog holds the translations of every supported language
+type Catalog struct {
+	translations map[string]*Translation
+}
+
+// Load parses the embedded translation files
+func Load() (*Catalog, error) {
+	c := &Catalog{translations: make(map[string]*Translation)}
+	entries, err := fs.ReadDir(localesFS, "locales")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		lang := strings.TrimSuffix(e.Name(), ".json")
+		raw, err := localesFS.ReadFile("locales/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		t := &Translation{}
+		if err := json.Unmarshal(raw, t); err != nil {
+			return nil, fmt.Errorf("invalid translation %q: %w", lang, err)
+		}
+		c.translations[lang] = t
+	}
+	return c, nil
+}
+
+// Override merges o into the translation of lang, adding the language when
+// it has no embedded translation
+func (c *Catalog) Override(lang string, o *Translation) {
+	t, ok := c.translations[lang]
+	if !ok {
+		t = &Translation{}
+		c.translations[lang] = t
+	}
+	t.Messages = merge(t.Messages, o.Messages)
+	t.Descriptions = merge(t.Descriptions, o.Descriptions)
+	t.Labels = merge(t.Labels, o.Labels)
+	if o.TimestampLayout != "" {
+		t.TimestampLayout = o.TimestampLayout
+	}
+	if o.Name != "" {
+		t.Name = o.Name
+	}
+}
+
+func merge(dst, src map[string]string) map[string]string {
+	if dst == nil && len(src) > 0 {
+		dst = make(map[string]string, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// Get returns the translation for lang, or nil for unsupported languages and
+// the default language unless it has overrides
+func (c *Catalog) Get(lang string) *Translation {
+	return c.translations[lang]
+}
+
+// Languages returns every supported language, including the default one
+func (c *Catalog) Languages() []string {
+	langs := []string{DefaultLanguage}
+	for lang := range c.translations {
+		if lang != DefaultLanguage {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Negotiate picks the best supported language for an Accept-Language header
+// value, falling back to fallback
+func (c *Catalog) Negotiate(acceptLanguage, fallback string) string {
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		if tag == "*" {
+			break
+		}
+		if c.Supported(tag) {
+			return tag
+		}
+		if primary, _, ok := strings.Cut(tag, "-"); ok && c.Supported(primary) {
+			return primary
+		}
+	}
+	return fallback
+}
+
+// Supported reports whether lang is the default language or has a
+// translation
+func (c *Catalog) Supported(lang string) bool {
+	_, ok := c.translations[lang]
+	return ok || lang == DefaultLanguage
+}
+
+// parseAcceptLanguage returns the lower-cased language tags of an
+// Accept-Language header ordered by descending quality, dropping q=0 tags
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
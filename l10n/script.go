@@ -0,0 +1,61 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package l10n
+
+import (
+	_ "embed"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+//go:embed client.js
+var clientJS string
+
+// ClientScript returns the script behind the templates' l10nScript hook. It
+// carries the label translations of every language and translates the
+// data-l10n elements to the browser's preferred language.
+//
+// Templates place the hook after a "//" comment, so the script starts on a
+// new line.
+func (c *Catalog) ClientScript() (string, error) {
+	labels := make(map[string]map[string]string, len(c.translations))
+	for lang, t := range c.translations {
+		labels[lang] = t.Labels
+	}
+	// json.Marshal escapes <, > and &, so no label can close the script tag
+	raw, err := json.Marshal(labels)
+	if err != nil {
+		return "", err
+	}
+	rtl := make([]string, 0, len(rtlLanguages))
+	for lang := range rtlLanguages {
+		rtl = append(rtl, lang)
+	}
+	sort.Strings(rtl)
+	rawRTL, err := json.Marshal(rtl)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\nwindow.errorPagesL10n = ")
+	sb.Write(raw)
+	sb.WriteString(";\nwindow.errorPagesL10nRTL = ")
+	sb.Write(rawRTL)
+	sb.WriteString(";\n")
+	sb.WriteString(clientJS)
+	return sb.String(), nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package simulator runs the error pages plugin on the proxy-wasm SDK's
+// proxytest host, so configurations can be checked without Envoy: feed it
+// synthetic requests and upstream responses and inspect what the plugin
+// sends downstream.
+//
+//	sim, err := simulator.New(configYAML)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer sim.Close()
+//	resp := sim.Do(simulator.Request{Path: "/", Headers: [][2]string{{"accept-language", "de"}}},
+//		simulator.Response{Status: 503, Headers: [][2]string{{"content-type", "text/plain"}}, Body: []byte("upstream down")})
+//	fmt.Println(resp.Status, resp.Header("content-type"), len(resp.Body))
+//
+// The proxytest host is process-wide, so only one Simulator can be open at a
+// time; New blocks until the previous one is closed.
+package simulator
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/plugin"
+
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/proxytest"
+	"github.com/proxy-wasm/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// Version is reported by the simulated plugin, e.g. on the status endpoint
+const Version = "simulator"
+
+// Request is a downstream request. Empty fields default to GET / on
+// example.com.
+type Request struct {
+	Method  string
+	Path    string
+	Host    string
+	Headers [][2]string
+	// Route and VirtualHost name the Envoy route the request matched. The
+	// test host keeps them for later requests that don't set them.
+	Route       string
+	VirtualHost string
+	// Properties are other host properties, such as the downstream TLS
+	// details of connection.*. They are kept for later requests as well.
+	Properties []Property
+}
+
+// Property is a host property, e.g. connection.tls_version:
+//
+//	simulator.Property{Path: []string{"connection", "tls_version"}, Value: []byte("TLSv1.3")}
+type Property struct {
+	Path  []string
+	Value []byte
+}
+
+// Response is an upstream response, or the response the plugin sent
+// downstream
+type Response struct {
+	Status  int
+	Headers [][2]string
+	Body    []byte
+	// Local is set on results the plugin sent itself (e.g. debug endpoints)
+	// without contacting the upstream
+	Local bool
+}
+
+// Header returns the first value of the named header, case-insensitively
+func (r *Response) Header(name string) string {
+	for _, h := range r.Headers {
+		if strings.EqualFold(h[0], name) {
+			return h[1]
+		}
+	}
+	return ""
+}
+
+// Simulator is a started plugin instance
+type Simulator struct {
+	host     proxytest.HostEmulator
+	release  func()
+	answered int // HTTP calls of the plugin answered by Respond
+}
+
+// New starts the plugin with the given YAML configuration. An empty
+// configuration uses the built-in defaults. Starting fails with the plugin's
+// critical log messages if the configuration is rejected.
+func New(configYAML []byte) (*Simulator, error) {
+	opt := proxytest.NewEmulatorOption().
+		WithVMContext(plugin.NewVMContext(Version, nil)).
+		WithPluginConfiguration(configYAML)
+	host, release := proxytest.NewHostEmulator(opt)
+	if status := host.StartPlugin(); status != types.OnPluginStartStatusOK {
+		msg := strings.Join(host.GetCriticalLogs(), "; ")
+		release()
+		if msg == "" {
+			msg = "plugin failed to start"
+		}
+		return nil, errors.New(msg)
+	}
+	return &Simulator{host: host, release: release}, nil
+}
+
+// Close stops the plugin and releases the proxytest host
+func (s *Simulator) Close() {
+	s.release()
+}
+
+// Do passes req and the upstream response through the plugin and returns
+// the response sent downstream
+func (s *Simulator) Do(req Request, upstream Response) *Response {
+	id := s.host.InitializeHttpContext()
+	defer s.host.CompleteHttpContext(id)
+
+	if local := s.requestHeaders(id, req); local != nil {
+		return local
+	}
+
+	headers := append([][2]string{{":status", strconv.Itoa(upstream.Status)}}, upstream.Headers...)
+	if len(upstream.Body) > 0 {
+		headers = append(headers, [2]string{"content-length", strconv.Itoa(len(upstream.Body))})
+	}
+	s.host.CallOnResponseHeaders(id, headers, len(upstream.Body) == 0)
+	if local := s.localResponse(id); local != nil {
+		return local
+	}
+	if len(upstream.Body) > 0 {
+		s.host.CallOnResponseBody(id, upstream.Body, true)
+	}
+
+	resp := &Response{Status: upstream.Status, Body: s.host.GetCurrentResponseBody(id)}
+	for _, h := range s.host.GetCurrentResponseHeaders(id) {
+		if h[0] == ":status" {
+			resp.Status, _ = strconv.Atoi(h[1])
+			continue
+		}
+		resp.Headers = append(resp.Headers, h)
+	}
+	return resp
+}
+
+// Get sends a request that is answered by the plugin itself, such as the
+// status endpoint. It returns nil if the plugin passed the request upstream.
+func (s *Simulator) Get(req Request) *Response {
+	id := s.host.InitializeHttpContext()
+	defer s.host.CompleteHttpContext(id)
+	return s.requestHeaders(id, req)
+}
+
+// Tick triggers the plugin's periodic work, such as log summaries
+func (s *Simulator) Tick() {
+	s.host.Tick()
+}
+
+// Respond answers the pending HTTP calls of the plugin, such as incident
+// document fetches, with resp and returns the clusters they were sent to
+func (s *Simulator) Respond(resp Response) []string {
+	callouts := s.host.GetCalloutAttributesFromContext(proxytest.PluginContextID)
+	var clusters []string
+	headers := append([][2]string{{":status", strconv.Itoa(resp.Status)}}, resp.Headers...)
+	for _, c := range callouts[s.answered:] {
+		s.host.CallOnHttpCallResponse(c.CalloutID, headers, nil, resp.Body)
+		clusters = append(clusters, c.Upstream)
+	}
+	s.answered = len(callouts)
+	return clusters
+}
+
+// Logs returns everything the plugin logged at info level and above
+func (s *Simulator) Logs() []string {
+	var logs []string
+	logs = append(logs, s.host.GetInfoLogs()...)
+	logs = append(logs, s.host.GetWarnLogs()...)
+	logs = append(logs, s.host.GetErrorLogs()...)
+	logs = append(logs, s.host.GetCriticalLogs()...)
+	return logs
+}
+
+func (s *Simulator) requestHeaders(id uint32, req Request) *Response {
+	method, path, host := req.Method, req.Path, req.Host
+	if method == "" {
+		method = "GET"
+	}
+	if path == "" {
+		path = "/"
+	}
+	if host == "" {
+		host = "example.com"
+	}
+	if req.Route != "" {
+		s.host.SetProperty([]string{"route_name"}, []byte(req.Route))
+	}
+	if req.VirtualHost != "" {
+		s.host.SetProperty([]string{"xds", "virtual_host_name"}, []byte(req.VirtualHost))
+	}
+	for _, p := range req.Properties {
+		s.host.SetProperty(p.Path, p.Value)
+	}
+	headers := append([][2]string{{":method", method}, {":path", path}, {":authority", host}, {":scheme", "https"}}, req.Headers...)
+	s.host.CallOnRequestHeaders(id, headers, true)
+	return s.localResponse(id)
+}
+
+func (s *Simulator) localResponse(id uint32) *Response {
+	local := s.host.GetSentLocalResponse(id)
+	if local == nil {
+		return nil
+	}
+	return &Response{Status: int(local.StatusCode), Headers: local.Headers, Body: local.Data, Local: true}
+}
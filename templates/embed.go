@@ -1,13 +1,20 @@
 package templates
 
 import (
-	"embed"
 	"fmt"
 	"io/fs"
+	"strings"
+	"sync"
 )
 
-//go:embed *.html
-var TemplatesFS embed.FS
+//go:generate go run gen_themes.go -config ../config.yaml
+
+// loaded caches templates by file name, so that a theme is read and
+// decompressed once however many times it is looked up at startup
+var loaded = struct {
+	sync.Mutex
+	templates map[string][]byte
+}{templates: map[string][]byte{}}
 
 func GetTemplate(theme string) ([]byte, error) {
 	filename := theme
@@ -15,10 +22,28 @@ func GetTemplate(theme string) ([]byte, error) {
 		filename = filename + ".html"
 	}
 
+	loaded.Lock()
+	defer loaded.Unlock()
+	if data, ok := loaded.templates[filename]; ok {
+		return data, nil
+	}
+
 	data, err := TemplatesFS.ReadFile(filename)
-	if err != nil {
+	if err == nil {
+		loaded.templates[filename] = data
+		return data, nil
+	}
+
+	// Themes may be stored gzip-compressed, see gen_themes.go
+	compressed, gzErr := TemplatesFS.ReadFile(filename + ".gz")
+	if gzErr != nil {
 		return nil, fmt.Errorf("template %q not found: %w", theme, err)
 	}
+	data, err = decompress(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %w", theme, err)
+	}
+	loaded.templates[filename] = data
 	return data, nil
 }
 
@@ -30,8 +55,9 @@ func GetTemplateNames() ([]string, error) {
 
 	var names []string
 	for _, e := range entries {
-		if !e.IsDir() && len(e.Name()) > 5 && e.Name()[len(e.Name())-5:] == ".html" {
-			names = append(names, e.Name()[:len(e.Name())-5])
+		name := strings.TrimSuffix(e.Name(), ".gz")
+		if !e.IsDir() && len(name) > 5 && name[len(name)-5:] == ".html" {
+			names = append(names, name[:len(name)-5])
 		}
 	}
 	return names, nil
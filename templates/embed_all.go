@@ -0,0 +1,12 @@
+//go:build !themes_select
+
+package templates
+
+import "embed"
+
+// TemplatesFS holds every theme and its manifest. Build with -tags
+// themes_select after running go generate to embed only the configured themes
+// instead.
+//
+//go:embed *.html *.json
+var TemplatesFS embed.FS
@@ -0,0 +1,140 @@
+//go:build ignore
+
+// gen_themes writes embed_selected.go, which embeds only the themes named in
+// the plugin configuration (plus the app-down fallback) when the plugin is
+// built with -tags themes_select. With -gzip the selected themes are stored
+// gzip-compressed and decompressed by the plugin at startup.
+//
+// Usage:
+//
+//	go run gen_themes.go -config ../config.yaml
+//	go run gen_themes.go -themes cats,connection
+//	go run gen_themes.go -all -gzip
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ishioni/envoy-wasm-error-pages/internal/config"
+)
+
+// fallbackTheme is always embedded since the plugin falls back to it when the
+// configured theme is missing
+const fallbackTheme = "app-down"
+
+func main() {
+	configPath := flag.String("config", "", "plugin configuration to read themes from")
+	themeList := flag.String("themes", "", "comma-separated themes to embed (overrides -config)")
+	all := flag.Bool("all", false, "embed every theme (overrides -themes and -config)")
+	compress := flag.Bool("gzip", false, "store the embedded themes gzip-compressed")
+	output := flag.String("o", "embed_selected.go", "output file")
+	flag.Parse()
+
+	themes := map[string]bool{fallbackTheme: true}
+	switch {
+	case *all:
+		matches, err := filepath.Glob("*.html")
+		if err != nil {
+			log.Fatalf("failed to list themes: %v", err)
+		}
+		for _, m := range matches {
+			themes[strings.TrimSuffix(m, ".html")] = true
+		}
+	case *themeList != "":
+		for _, t := range strings.Split(*themeList, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				themes[t] = true
+			}
+		}
+	case *configPath != "":
+		raw, err := os.ReadFile(*configPath)
+		if err != nil {
+			log.Fatalf("failed to read config: %v", err)
+		}
+		cfg, err := config.Parse(raw)
+		if err != nil {
+			log.Fatalf("failed to parse config: %v", err)
+		}
+		for _, t := range cfg.Themes() {
+			themes[t] = true
+		}
+	default:
+		log.Fatal("either -config or -themes is required")
+	}
+
+	var files []string
+	for t := range themes {
+		file := strings.TrimSuffix(t, ".html") + ".html"
+		if _, err := os.Stat(file); err != nil {
+			log.Fatalf("theme %q not found: %v", t, err)
+		}
+		if *compress {
+			gz, err := gzipFile(file)
+			if err != nil {
+				log.Fatalf("failed to compress theme %q: %v", t, err)
+			}
+			file = gz
+		}
+		files = append(files, file)
+		// Manifests are tiny, so they are never compressed
+		if manifest := strings.TrimSuffix(t, ".html") + ".json"; fileExists(manifest) {
+			files = append(files, manifest)
+		}
+	}
+	sort.Strings(files)
+
+	src := fmt.Sprintf(`// Code generated by gen_themes.go; DO NOT EDIT.
+
+//go:build themes_select
+
+package templates
+
+import "embed"
+
+// TemplatesFS holds only the themes selected at build time, with their
+// manifests.
+//
+//go:embed %s
+var TemplatesFS embed.FS
+`, strings.Join(files, " "))
+
+	if err := os.WriteFile(*output, []byte(src), 0o644); err != nil {
+		log.Fatalf("failed to write %s: %v", *output, err)
+	}
+	log.Printf("embedding %d themes: %s", len(themes), strings.Join(files, ", "))
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+// gzipFile writes a gzip-compressed copy of file next to it and returns the
+// name of the copy
+func gzipFile(file string) (string, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := zw.Write(raw); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	out := file + ".gz"
+	return out, os.WriteFile(out, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,371 @@
+package templates
+
+import "errors"
+
+// decompress inflates a gzip-compressed theme. It is a small port of zlib's
+// puff.c rather than compress/gzip, whose decoder adds more to the plugin
+// than compressing the built-in themes saves.
+func decompress(compressed []byte) ([]byte, error) {
+	in, err := gzipBody(compressed)
+	if err != nil {
+		return nil, err
+	}
+	f := &inflater{in: in}
+	for last := 0; last == 0; {
+		if last, err = f.bits(1); err != nil {
+			return nil, err
+		}
+		kind, err := f.bits(2)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case 0:
+			err = f.stored()
+		case 1:
+			err = f.fixed()
+		case 2:
+			err = f.dynamic()
+		default:
+			err = errCorrupt
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	trailer := f.in[f.pos:]
+	if len(trailer) < 8 {
+		return nil, errCorrupt
+	}
+	if le32(trailer) != crc32(f.out) || le32(trailer[4:]) != uint32(len(f.out)) {
+		return nil, errors.New("compressed theme checksum mismatch")
+	}
+	return f.out, nil
+}
+
+var errCorrupt = errors.New("corrupt compressed theme")
+
+const (
+	gzipFlagHCRC    = 1 << 1
+	gzipFlagExtra   = 1 << 2
+	gzipFlagName    = 1 << 3
+	gzipFlagComment = 1 << 4
+)
+
+// gzipBody skips the gzip header, returning the deflate stream followed by
+// the trailer
+func gzipBody(b []byte) ([]byte, error) {
+	if len(b) < 18 || b[0] != 0x1f || b[1] != 0x8b || b[2] != 8 {
+		return nil, errors.New("compressed theme is not gzip data")
+	}
+	flags, b := b[3], b[10:]
+	if flags&gzipFlagExtra != 0 {
+		if len(b) < 2 {
+			return nil, errCorrupt
+		}
+		n := 2 + (int(b[0]) | int(b[1])<<8)
+		if len(b) < n {
+			return nil, errCorrupt
+		}
+		b = b[n:]
+	}
+	for _, flag := range []byte{gzipFlagName, gzipFlagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+		n := 0
+		for n < len(b) && b[n] != 0 {
+			n++
+		}
+		if n == len(b) {
+			return nil, errCorrupt
+		}
+		b = b[n+1:]
+	}
+	if flags&gzipFlagHCRC != 0 {
+		if len(b) < 2 {
+			return nil, errCorrupt
+		}
+		b = b[2:]
+	}
+	return b, nil
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// crc32 computes the IEEE checksum bit by bit, which is fast enough for
+// themes decompressed once at startup
+func crc32(b []byte) uint32 {
+	crc := ^uint32(0)
+	for _, c := range b {
+		crc ^= uint32(c)
+		for i := 0; i < 8; i++ {
+			crc = crc>>1 ^ 0xedb88320&-(crc&1)
+		}
+	}
+	return ^crc
+}
+
+// maxBits is the longest code allowed by deflate
+const maxBits = 15
+
+type inflater struct {
+	in     []byte
+	pos    int
+	bitbuf uint32
+	bitcnt uint
+	out    []byte
+}
+
+// bits reads need bits from the input, least significant bit first
+func (f *inflater) bits(need uint) (int, error) {
+	val := f.bitbuf
+	for f.bitcnt < need {
+		if f.pos >= len(f.in) {
+			return 0, errCorrupt
+		}
+		val |= uint32(f.in[f.pos]) << f.bitcnt
+		f.pos++
+		f.bitcnt += 8
+	}
+	f.bitbuf = val >> need
+	f.bitcnt -= need
+	return int(val & (1<<need - 1)), nil
+}
+
+func (f *inflater) stored() error {
+	f.bitbuf, f.bitcnt = 0, 0
+	if f.pos+4 > len(f.in) {
+		return errCorrupt
+	}
+	n := int(f.in[f.pos]) | int(f.in[f.pos+1])<<8
+	if ^n&0xffff != int(f.in[f.pos+2])|int(f.in[f.pos+3])<<8 {
+		return errCorrupt
+	}
+	f.pos += 4
+	if f.pos+n > len(f.in) {
+		return errCorrupt
+	}
+	f.out = append(f.out, f.in[f.pos:f.pos+n]...)
+	f.pos += n
+	return nil
+}
+
+// huffman is a canonical Huffman code: the number of codes of each length
+// and the symbols ordered by code
+type huffman struct {
+	count  [maxBits + 1]int
+	symbol []int
+}
+
+// newHuffman builds the code for the given symbol code lengths. left is
+// negative for an over-subscribed code and positive for an incomplete one.
+func newHuffman(lengths []int) (h *huffman, left int) {
+	h = &huffman{symbol: make([]int, len(lengths))}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	if h.count[0] == len(lengths) {
+		return h, 0
+	}
+
+	left = 1
+	for l := 1; l <= maxBits; l++ {
+		left <<= 1
+		if left -= h.count[l]; left < 0 {
+			return h, left
+		}
+	}
+
+	var offs [maxBits + 1]int
+	for l := 1; l < maxBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = sym
+			offs[l]++
+		}
+	}
+	return h, left
+}
+
+func (f *inflater) decode(h *huffman) (int, error) {
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxBits; l++ {
+		b, err := f.bits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= b
+		count := h.count[l]
+		if code-count < first {
+			return h.symbol[index+code-first], nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, errCorrupt
+}
+
+var (
+	lengthBase  = [29]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+)
+
+// codes decodes literals and length/distance pairs until the end of block
+func (f *inflater) codes(lencode, distcode *huffman) error {
+	for {
+		sym, err := f.decode(lencode)
+		if err != nil {
+			return err
+		}
+		switch {
+		case sym < 256:
+			f.out = append(f.out, byte(sym))
+			continue
+		case sym == 256:
+			return nil
+		}
+
+		sym -= 257
+		if sym >= len(lengthBase) {
+			return errCorrupt
+		}
+		extra, err := f.bits(lengthExtra[sym])
+		if err != nil {
+			return err
+		}
+		length := lengthBase[sym] + extra
+
+		if sym, err = f.decode(distcode); err != nil {
+			return err
+		}
+		if sym >= len(distBase) {
+			return errCorrupt
+		}
+		if extra, err = f.bits(distExtra[sym]); err != nil {
+			return err
+		}
+		dist := distBase[sym] + extra
+		if dist > len(f.out) {
+			return errCorrupt
+		}
+		for ; length > 0; length-- {
+			f.out = append(f.out, f.out[len(f.out)-dist])
+		}
+	}
+}
+
+func (f *inflater) fixed() error {
+	var lengths [288 + 30]int
+	for sym := range lengths {
+		switch {
+		case sym < 144:
+			lengths[sym] = 8
+		case sym < 256:
+			lengths[sym] = 9
+		case sym < 280:
+			lengths[sym] = 7
+		case sym < 288:
+			lengths[sym] = 8
+		default:
+			lengths[sym] = 5
+		}
+	}
+	lencode, _ := newHuffman(lengths[:288])
+	distcode, _ := newHuffman(lengths[288:])
+	return f.codes(lencode, distcode)
+}
+
+// codeLengthOrder is the order in which code length code lengths are sent
+var codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+func (f *inflater) dynamic() error {
+	nlen, err := f.bits(5)
+	if err != nil {
+		return err
+	}
+	ndist, err := f.bits(5)
+	if err != nil {
+		return err
+	}
+	ncode, err := f.bits(4)
+	if err != nil {
+		return err
+	}
+	nlen, ndist, ncode = nlen+257, ndist+1, ncode+4
+	if nlen > 286 || ndist > 30 {
+		return errCorrupt
+	}
+
+	var lengths [286 + 30]int
+	for i := 0; i < ncode; i++ {
+		if lengths[codeLengthOrder[i]], err = f.bits(3); err != nil {
+			return err
+		}
+	}
+	lencode, left := newHuffman(lengths[:19])
+	if left != 0 {
+		return errCorrupt
+	}
+
+	for i := 0; i < nlen+ndist; {
+		sym, err := f.decode(lencode)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = sym
+			i++
+			continue
+		}
+
+		var l, repeat int
+		switch sym {
+		case 16:
+			if i == 0 {
+				return errCorrupt
+			}
+			l = lengths[i-1]
+			repeat, err = f.bits(2)
+			repeat += 3
+		case 17:
+			repeat, err = f.bits(3)
+			repeat += 3
+		default:
+			repeat, err = f.bits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+repeat > nlen+ndist {
+			return errCorrupt
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[i] = l
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return errCorrupt
+	}
+
+	// Incomplete codes are only allowed for a single length-1 code
+	lencode, left = newHuffman(lengths[:nlen])
+	if left < 0 || left > 0 && nlen != lencode.count[0]+lencode.count[1] {
+		return errCorrupt
+	}
+	distcode, left := newHuffman(lengths[nlen : nlen+ndist])
+	if left < 0 || left > 0 && ndist != distcode.count[0]+distcode.count[1] {
+		return errCorrupt
+	}
+	return f.codes(lencode, distcode)
+}
@@ -0,0 +1,88 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+)
+
+// Theme describes an embedded theme. DisplayName, Description, DarkMode,
+// L10n and Variables come from the theme's manifest, <theme>.json next to
+// its template; Placeholders are read from the template itself.
+type Theme struct {
+	Name         string              `json:"name,omitempty"`
+	DisplayName  string              `json:"display_name"`
+	Description  string              `json:"description"`
+	DarkMode     bool                `json:"dark_mode"`              // follows prefers-color-scheme
+	L10n         bool                `json:"l10n"`                   // labels are translatable
+	Variables    map[string]Variable `json:"variables,omitempty"`    // tunables, set with theme_variables
+	Placeholders []string            `json:"placeholders,omitempty"` // e.g. code, message, request_id
+}
+
+// Variable is a tunable of a theme, such as a color, read by the template
+// with {{ var "name" }}
+type Variable struct {
+	Default     string `json:"default"`
+	Description string `json:"description,omitempty"`
+}
+
+// VariableDefaults returns the default value of every variable of the theme
+func (t Theme) VariableDefaults() map[string]string {
+	defaults := make(map[string]string, len(t.Variables))
+	for name, v := range t.Variables {
+		defaults[name] = v.Default
+	}
+	return defaults
+}
+
+// GetTheme returns the metadata of an embedded theme
+func GetTheme(name string) (Theme, error) {
+	raw, err := GetTemplate(name)
+	if err != nil {
+		return Theme{}, err
+	}
+	manifest, err := TemplatesFS.ReadFile(name + ".json")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Theme{}, fmt.Errorf("theme %q: %w", name, err)
+	}
+	return NewTheme(name, raw, manifest)
+}
+
+// NewTheme builds the metadata of a theme from its template and manifest.
+// Themes without a manifest get their name as display name.
+func NewTheme(name string, template, manifest []byte) (Theme, error) {
+	theme := Theme{DisplayName: name}
+	if manifest != nil {
+		if err := json.Unmarshal(manifest, &theme); err != nil {
+			return Theme{}, fmt.Errorf("theme %q: invalid manifest: %w", name, err)
+		}
+	}
+	theme.Name = name
+
+	handler, err := errorpages.NewWithTemplate(template, "")
+	if err != nil {
+		return Theme{}, fmt.Errorf("theme %q: %w", name, err)
+	}
+	theme.Placeholders = handler.Placeholders()
+	return theme, nil
+}
+
+// GetThemes returns the metadata of every embedded theme, ordered by name
+func GetThemes() ([]Theme, error) {
+	names, err := GetTemplateNames()
+	if err != nil {
+		return nil, err
+	}
+	themes := make([]Theme, 0, len(names))
+	for _, name := range names {
+		theme, err := GetTheme(name)
+		if err != nil {
+			return nil, err
+		}
+		themes = append(themes, theme)
+	}
+	return themes, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2020-2024 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package themes gives access to the themes built into the plugin, so Go
+// services can render the same pages with the errorpages package:
+//
+//	handler, err := themes.New("cats", version)
+//
+// Which themes are available depends on the build, see the themes_select
+// build tag.
+package themes
+
+import (
+	"github.com/ishioni/envoy-wasm-error-pages/errorpages"
+	"github.com/ishioni/envoy-wasm-error-pages/templates"
+)
+
+// Theme describes a theme: display name, description, whether it follows the
+// system dark mode and has translatable labels, its variables and the
+// placeholders it uses
+type Theme = templates.Theme
+
+// Variable is a tunable of a theme with its default value
+type Variable = templates.Variable
+
+// New returns a handler rendering the named theme, with the defaults of the
+// theme's variables applied
+func New(name, version string) (*errorpages.Handler, error) {
+	raw, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	theme, err := Info(name)
+	if err != nil {
+		return nil, err
+	}
+	handler, err := errorpages.NewWithTemplate(raw, version)
+	if err != nil {
+		return nil, err
+	}
+	handler.SetVariableDefaults(theme.VariableDefaults())
+	return handler, nil
+}
+
+// List returns the names of the available themes, in alphabetical order
+func List() ([]string, error) {
+	return templates.GetTemplateNames()
+}
+
+// Get returns the template of the named theme
+func Get(name string) ([]byte, error) {
+	return templates.GetTemplate(name)
+}
+
+// Info returns the metadata of the named theme
+func Info(name string) (Theme, error) {
+	return templates.GetTheme(name)
+}
+
+// All returns the metadata of every available theme, ordered by name
+func All() ([]Theme, error) {
+	return templates.GetThemes()
+}